@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"errors"
+	"path"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kplachkov/helm-mirror/service"
+)
+
+var (
+	exportInclude  []string
+	exportExclude  []string
+	exportImages   bool
+	exportInsecure bool
+)
+
+const exportDesc = `Pack the charts of a mirror folder into a single archive
+to carry them into a disconnected network. Example:
+
+  - helm mirror export /yourorg/charts /media/usb/charts.tgz
+  - helm mirror export /yourorg/charts /media/usb/charts.tgz --include 'redis*'
+  - helm mirror export /yourorg/charts /media/usb/charts.tgz --images
+
+The archive holds the selected charts with their provenance
+files, an index file listing them with URLs relative to the
+archive, and a manifest.yaml with the SHA-256 checksum of
+every file. Unpack it with the import command, which verifies
+the checksums and sets the root URL of the charts.
+
+With --images, the container images found in the charts, as
+listed by inspect-images, are copied from their registries
+into an OCI image layout under the images folder of the
+archive. The credentials of the registries are read from the
+Docker and Podman auth files.
+
+The folder and the archive have to be full paths.
+`
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export [folder] [archive]",
+	Short: "Pack the charts of a folder into an archive for an air-gapped network.",
+	Long:  exportDesc,
+	Args:  validateExportArgs,
+	RunE:  runExport,
+}
+
+func init() {
+	exportCmd.Flags().StringArrayVar(&exportInclude, "include", nil, "export only the charts whose name matches this glob, or regular expression with the regex: prefix (repeatable)")
+	exportCmd.Flags().StringArrayVar(&exportExclude, "exclude", nil, "do not export the charts whose name matches this glob, or regular expression with the regex: prefix (repeatable)")
+	exportCmd.Flags().BoolVar(&exportImages, "images", false, "copy the container images of the charts into an OCI image layout in the archive")
+	exportCmd.Flags().BoolVar(&exportInsecure, "insecure-registries", false, "skip the TLS verification of the image registries, falling back to plain HTTP")
+	rootCmd.AddCommand(exportCmd)
+}
+
+func validateExportArgs(cmd *cobra.Command, args []string) error {
+	if len(args) < 2 {
+		logger.Print("error: requires at least two args to execute")
+		return errors.New("error: requires at least two args")
+	}
+	if !path.IsAbs(args[0]) {
+		logger.Printf("error: please provide a full path for folder: `%s`", args[0])
+		return errors.New("error: please provide a full path for folder")
+	}
+	if !path.IsAbs(args[1]) {
+		logger.Printf("error: please provide a full path for archive: `%s`", args[1])
+		return errors.New("error: please provide a full path for archive")
+	}
+	return nil
+}
+
+func runExport(cmd *cobra.Command, args []string) error {
+	options := service.ExportOptions{
+		Include:            exportInclude,
+		Exclude:            exportExclude,
+		Images:             exportImages,
+		InsecureRegistries: exportInsecure,
+	}
+	exportService := service.NewExportService(args[0], args[1], options, Verbose, IgnoreErrors, logger)
+	return exportService.Export()
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/repo"
+
+	"github.com/kplachkov/helm-mirror/service"
+)
+
+func Test_validateExportArgs(t *testing.T) {
+	c := &cobra.Command{}
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{"1", []string{}, true},
+		{"2", []string{"/folder"}, true},
+		{"3", []string{"folder", "/charts.tgz"}, true},
+		{"4", []string{"/folder", "charts.tgz"}, true},
+		{"5", []string{"/folder", "/charts.tgz"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateExportArgs(c, tt.args); (err != nil) != tt.wantErr {
+				t.Errorf("validateExportArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_runExport(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirror")
+	if err != nil {
+		t.Errorf("creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestRepository(t, dir)
+	tests := []struct {
+		name    string
+		folder  string
+		include []string
+		images  bool
+		wantErr bool
+	}{
+		{"1", dir, nil, false, false},
+		{"2", dir, []string{"chart"}, false, false},
+		{"3", dir, []string{"regex:("}, false, true},
+		{"4", path.Join(dir, "missing"), nil, false, true},
+		{"5", dir, nil, true, !service.ImageCopySupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exportInclude, exportImages = tt.include, tt.images
+			defer func() { exportInclude, exportImages = nil, false }()
+			if err := runExport(&cobra.Command{}, []string{tt.folder, path.Join(dir, tt.name+".tgz")}); (err != nil) != tt.wantErr {
+				t.Errorf("runExport() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// writeTestRepository packages a chart into dir and writes its index file.
+func writeTestRepository(t *testing.T, dir string) {
+	_, err := chartutil.Save(&chart.Chart{Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "chart", Version: "1.0.0"}}, dir)
+	if err != nil {
+		t.Fatalf("packaging chart: %s", err)
+	}
+	index, err := repo.IndexDirectory(dir, "")
+	if err != nil {
+		t.Fatalf("indexing chart: %s", err)
+	}
+	err = index.WriteFile(path.Join(dir, "index.yaml"), 0644)
+	if err != nil {
+		t.Fatalf("writing index: %s", err)
+	}
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"errors"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kplachkov/helm-mirror/service"
+)
+
+var importRootURL string
+
+const importDesc = `Unpack an archive made by the export command into a
+folder. Example:
+
+  - helm mirror import /media/usb/charts.tgz /yourorg/charts
+  - helm mirror import /media/usb/charts.tgz /yourorg/charts --new-root-url https://mirror.local.lan/charts
+
+The files of the archive are checked against the checksums of
+its manifest first, and nothing is written into the folder
+when one is missing, altered or not listed. The charts are
+then added to the index file of the folder, with URLs under
+the new root URL or relative to the index file. The container
+images of the archive are added to the OCI image layout of the
+images folder.
+
+The archive and the folder have to be full paths.
+`
+
+// importCmd represents the import command
+var importCmd = &cobra.Command{
+	Use:   "import [archive] [folder]",
+	Short: "Verify and unpack an archive made by export into a folder.",
+	Long:  importDesc,
+	Args:  validateImportArgs,
+	RunE:  runImport,
+}
+
+func init() {
+	importCmd.Flags().StringVar(&importRootURL, "new-root-url", "", "root url of the charts in the index file (eg: `https://mirror.local.lan/charts`)")
+	rootCmd.AddCommand(importCmd)
+}
+
+func validateImportArgs(cmd *cobra.Command, args []string) error {
+	if len(args) < 2 {
+		logger.Print("error: requires at least two args to execute")
+		return errors.New("error: requires at least two args")
+	}
+	if !path.IsAbs(args[0]) {
+		logger.Printf("error: please provide a full path for archive: `%s`", args[0])
+		return errors.New("error: please provide a full path for archive")
+	}
+	if !path.IsAbs(args[1]) {
+		logger.Printf("error: please provide a full path for folder: `%s`", args[1])
+		return errors.New("error: please provide a full path for folder")
+	}
+	return nil
+}
+
+func runImport(cmd *cobra.Command, args []string) error {
+	rootURL := &url.URL{}
+	if importRootURL != "" {
+		var err error
+		rootURL, err = url.Parse(importRootURL)
+		if err != nil {
+			logger.Printf("error: new-root-url not a valid URL: %s", err)
+			return err
+		}
+
+		if !strings.Contains(rootURL.Scheme, "http") {
+			logger.Printf("error: new-root-url not a valid URL protocol: `%s`", rootURL.Scheme)
+			return errors.New("error: new-root-url not a valid URL protocol")
+		}
+	}
+
+	importService := service.NewImportService(args[0], args[1], rootURL.String(), Verbose, logger)
+	return importService.Import()
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func Test_validateImportArgs(t *testing.T) {
+	c := &cobra.Command{}
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{"1", []string{}, true},
+		{"2", []string{"/charts.tgz"}, true},
+		{"3", []string{"charts.tgz", "/folder"}, true},
+		{"4", []string{"/charts.tgz", "folder"}, true},
+		{"5", []string{"/charts.tgz", "/folder"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateImportArgs(c, tt.args); (err != nil) != tt.wantErr {
+				t.Errorf("validateImportArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_runImport(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirror")
+	if err != nil {
+		t.Errorf("creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	source := path.Join(dir, "source")
+	os.MkdirAll(source, 0744)
+	writeTestRepository(t, source)
+	archive := path.Join(dir, "charts.tgz")
+	err = runExport(&cobra.Command{}, []string{source, archive})
+	if err != nil {
+		t.Fatalf("exporting charts: %s", err)
+	}
+	tests := []struct {
+		name    string
+		archive string
+		rootURL string
+		wantErr bool
+	}{
+		{"1", archive, "", false},
+		{"2", archive, "https://mirror.local.lan/charts", false},
+		{"3", archive, "ftp://mirror.local.lan/charts", true},
+		{"4", path.Join(dir, "missing.tgz"), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			importRootURL = tt.rootURL
+			defer func() { importRootURL = "" }()
+			if err := runImport(&cobra.Command{}, []string{tt.archive, path.Join(dir, tt.name)}); (err != nil) != tt.wantErr {
+				t.Errorf("runImport() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"errors"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kplachkov/helm-mirror/service"
+)
+
+var indexRootURL string
+
+const indexDesc = `Rebuild the index file of a folder from the chart
+archives present in it. Example:
+
+  - helm mirror index /yourorg/charts
+  - helm mirror index /yourorg/charts --new-root-url https://mirror.local.lan/charts
+
+The digests are computed from the archives. The charts
+already listed in the existing index file keep their
+creation date.
+
+The folder has to be a full path.
+`
+
+// indexCmd represents the index command
+var indexCmd = &cobra.Command{
+	Use:   "index [folder]",
+	Short: "Rebuild the index file from the charts in a folder.",
+	Long:  indexDesc,
+	Args:  validateIndexArgs,
+	RunE:  runIndex,
+}
+
+func init() {
+	indexCmd.Flags().StringVar(&indexRootURL, "new-root-url", "", "root url of the charts in the index file (eg: `https://mirror.local.lan/charts`)")
+	rootCmd.AddCommand(indexCmd)
+}
+
+func validateIndexArgs(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		logger.Print("error: requires at least one arg to execute")
+		return errors.New("error: requires at least one arg")
+	}
+	if !path.IsAbs(args[0]) {
+		logger.Printf("error: please provide a full path for folder: `%s`", args[0])
+		return errors.New("error: please provide a full path for folder")
+	}
+	return nil
+}
+
+func runIndex(cmd *cobra.Command, args []string) error {
+	rootURL := &url.URL{}
+	if indexRootURL != "" {
+		var err error
+		rootURL, err = url.Parse(indexRootURL)
+		if err != nil {
+			logger.Printf("error: new-root-url not a valid URL: %s", err)
+			return err
+		}
+
+		if !strings.Contains(rootURL.Scheme, "http") {
+			logger.Printf("error: new-root-url not a valid URL protocol: `%s`", rootURL.Scheme)
+			return errors.New("error: new-root-url not a valid URL protocol")
+		}
+	}
+
+	indexService := service.NewIndexService(args[0], rootURL.String(), Verbose, IgnoreErrors, logger)
+	return indexService.Index()
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+func Test_validateIndexArgs(t *testing.T) {
+	c := &cobra.Command{}
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{"1", []string{}, true},
+		{"2", []string{"folder"}, true},
+		{"3", []string{"/folder"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateIndexArgs(c, tt.args); (err != nil) != tt.wantErr {
+				t.Errorf("validateIndexArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_runIndex(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirror")
+	if err != nil {
+		t.Errorf("creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	_, err = chartutil.Save(&chart.Chart{Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "chart", Version: "1.0.0"}}, dir)
+	if err != nil {
+		t.Fatalf("packaging chart: %s", err)
+	}
+	tests := []struct {
+		name    string
+		folder  string
+		rootURL string
+		wantErr bool
+	}{
+		{"1", dir, "", false},
+		{"2", dir, "https://mirror.local.lan/charts", false},
+		{"3", dir, "ftp://mirror.local.lan/charts", true},
+		{"4", path.Join(dir, "missing"), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			indexRootURL = tt.rootURL
+			defer func() { indexRootURL = "" }()
+			if err := runIndex(&cobra.Command{}, []string{tt.folder}); (err != nil) != tt.wantErr {
+				t.Errorf("runIndex() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -8,8 +8,10 @@ import (
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
+	"helm.sh/helm/v3/pkg/registry"
 	"helm.sh/helm/v3/pkg/repo"
 
 	"github.com/kplachkov/helm-mirror/service"
@@ -34,6 +36,32 @@ var (
 	certFile     string
 	keyFile      string
 	newRootURL   string
+	incremental  bool
+	quarantine   string
+	prov         bool
+	keyring      string
+	requireSign  bool
+	concurrency  int
+	retries      int
+	retryBackoff time.Duration
+	destUsername string
+	destPassword string
+	constraint   string
+	includePre   bool
+	excludePre   bool
+	include      []string
+	exclude      []string
+	includeFrom  string
+	keepVersions int
+	keepPer      string
+	since        string
+	until        string
+	withDeps     bool
+	prune        bool
+	pruneDryRun  bool
+	dryRun       bool
+	planFormat   string
+	regenIndex   bool
 )
 
 const rootDesc = `Mirror Helm Charts from an index file into a local folder.
@@ -45,6 +73,26 @@ helm mirror https://yourorg.com/charts /yourorg/charts
 This will download the index file and the charts into
 the folder indicated.
 
+Charts stored in an OCI registry are mirrored from their
+reference, and an index file is generated for them:
+
+helm mirror oci://registry.yourorg.com/charts/chart /yourorg/charts
+
+The charts can also be pushed to an OCI registry instead of
+a local folder, as <reference>/<chart name>:<chart version>:
+
+helm mirror https://yourorg.com/charts oci://registry.yourorg.com/charts
+
+or uploaded to a ChartMuseum server, over HTTPS with cm://
+and plain HTTP with cm+http://:
+
+helm mirror https://yourorg.com/charts cm://chartmuseum.yourorg.com
+
+or written to an S3 bucket, with the credentials and the
+endpoint from the standard AWS environment variables:
+
+helm mirror https://yourorg.com/charts s3://bucket/charts
+
 The index file is a yaml that contains a list of
 charts in this format. Example:
 
@@ -72,11 +120,13 @@ This will download these charts
 	https://kubernetes-charts.yourorganization.com/chart-1.0.0.tgz
 	https://kubernetes-charts.yourorganization.com/chart2-1.0.0.tgz
 
-into your destination folder.`
+into your destination folder. The index file written next to
+them lists only the mirrored charts, with their URLs relative to
+the index file or under --new-root-url.`
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
-	Use:   "mirror [Repo URL] [Destination Folder]",
+	Use:   "mirror [Repo URL] [Destination Folder|OCI Reference|ChartMuseum URL|S3 URL]",
 	Short: "Mirror Helm Charts from an index file into a local folder.",
 	Long:  rootDesc,
 	Args:  validateRootArgs,
@@ -98,13 +148,39 @@ func init() {
 	rootCmd.PersistentFlags().BoolVarP(&IgnoreErrors, "ignore-errors", "i", false, "ignores errors while downloading or processing charts")
 	rootCmd.PersistentFlags().BoolVarP(&AllVersions, "all-versions", "a", false, "gets all the versions of the charts in the chart repository")
 	rootCmd.Flags().StringVar(&chartName, "chart-name", "", "name of the chart that gets mirrored")
-	rootCmd.Flags().StringVar(&chartVersion, "chart-version", "", "specific version of the chart that is going to be mirrored")
+	rootCmd.Flags().StringVar(&chartVersion, "chart-version", "", "version or semver constraint of the chart that is going to be mirrored")
 	rootCmd.Flags().StringVar(&username, "username", "", "chart repository username")
 	rootCmd.Flags().StringVar(&password, "password", "", "chart repository password")
 	rootCmd.Flags().StringVar(&caFile, "ca-file", "", "verify certificates of HTTPS-enabled servers using this CA bundle")
 	rootCmd.Flags().StringVar(&certFile, "cert-file", "", "identify HTTPS client using this SSL certificate file")
 	rootCmd.Flags().StringVar(&keyFile, "key-file", "", "identify HTTPS client using this SSL key file")
 	rootCmd.Flags().StringVar(&newRootURL, "new-root-url", "", "New root url of the chart repository (eg: `https://mirror.local.lan/charts`)")
+	rootCmd.Flags().BoolVar(&incremental, "incremental", false, "skip the charts already in the destination folder whose digest matches the index file")
+	rootCmd.Flags().StringVar(&quarantine, "quarantine-dir", "", "folder where charts failing digest verification are kept when ignoring errors")
+	rootCmd.Flags().BoolVar(&prov, "prov", false, "mirror the provenance files of the charts")
+	rootCmd.Flags().StringVar(&keyring, "keyring", "", "keyring containing the public keys used to verify the signed charts")
+	rootCmd.Flags().BoolVar(&requireSign, "require-signed", false, "reject the charts without a provenance file")
+	rootCmd.Flags().IntVar(&concurrency, "concurrency", 1, "number of charts downloaded in parallel")
+	rootCmd.Flags().IntVar(&retries, "retries", 0, "number of times a failed download of the index file or a chart is retried")
+	rootCmd.Flags().DurationVar(&retryBackoff, "retry-backoff", time.Second, "initial wait between retries, doubled on every retry")
+	rootCmd.Flags().StringVar(&destUsername, "dest-username", "", "destination registry, ChartMuseum or S3 (access key) username")
+	rootCmd.Flags().StringVar(&destPassword, "dest-password", "", "destination registry, ChartMuseum or S3 (secret key) password")
+	rootCmd.Flags().StringVar(&constraint, "version-constraint", "", "semver constraint applied to the versions of every chart (eg: >=2.3 <3)")
+	rootCmd.Flags().BoolVar(&includePre, "include-prereleases", false, "match the pre-releases against the version constraints by their release version")
+	rootCmd.Flags().BoolVar(&excludePre, "exclude-prereleases", false, "never mirror pre-releases")
+	rootCmd.Flags().StringArrayVar(&include, "include", nil, "mirror only the charts whose name matches this glob, or regular expression with the regex: prefix (repeatable)")
+	rootCmd.Flags().StringArrayVar(&exclude, "exclude", nil, "do not mirror the charts whose name matches this glob, or regular expression with the regex: prefix (repeatable)")
+	rootCmd.Flags().StringVar(&includeFrom, "include-from", "", "file listing the chart name patterns to include, one per line")
+	rootCmd.Flags().IntVar(&keepVersions, "keep-versions", 0, "mirror only the newest N versions of every chart")
+	rootCmd.Flags().StringVar(&keepPer, "keep-versions-per", service.KeepPerChart, "version line the kept versions apply to: chart, major or minor")
+	rootCmd.Flags().StringVar(&since, "since", "", "mirror only the charts created since this date or duration ago (eg: 2023-01-31, 90d)")
+	rootCmd.Flags().StringVar(&until, "until", "", "mirror only the charts created until this date or duration ago (eg: 2023-12-31, 2w)")
+	rootCmd.Flags().BoolVar(&withDeps, "with-dependencies", false, "also mirror the dependencies of the charts from their own repositories")
+	rootCmd.Flags().BoolVar(&prune, "prune", false, "delete the charts of the destination folder that are not mirrored anymore")
+	rootCmd.Flags().BoolVar(&pruneDryRun, "prune-dry-run", false, "list the charts --prune would delete without deleting them")
+	rootCmd.Flags().BoolVar(&dryRun, "dry-run", false, "print the plan of the mirror without writing into the destination")
+	rootCmd.Flags().StringVar(&planFormat, "plan-format", service.PlanText, "format of the dry run plan: text or json")
+	rootCmd.Flags().BoolVar(&regenIndex, "regenerate-index", false, "rebuild the index file from all the charts present in the destination folder")
 	rootCmd.AddCommand(newVersionCmd())
 }
 
@@ -123,11 +199,11 @@ func validateRootArgs(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	if !strings.Contains(repoURL.Scheme, "http") {
+	if !strings.Contains(repoURL.Scheme, "http") && repoURL.Scheme != registry.OCIScheme {
 		logger.Printf("error: not a valid URL protocol: `%s`", repoURL.Scheme)
 		return errors.New("error: not a valid URL protocol")
 	}
-	if !path.IsAbs(args[1]) {
+	if !path.IsAbs(args[1]) && !service.IsRemote(args[1]) {
 		logger.Printf("error: please provide a full path for destination folder: `%s`", args[1])
 		return errors.New("error: please provide a full path for destination folder")
 	}
@@ -141,11 +217,39 @@ func runRoot(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	folder = args[1]
-	err = os.MkdirAll(folder, 0744)
-	if err != nil {
-		logger.Printf("error: cannot create destination folder: %s", err)
-		return err
+	destination := args[1]
+	if (prune || pruneDryRun) && service.IsRemote(destination) {
+		logger.Printf("error: prune applies to a destination folder only")
+		return errors.New("error: prune applies to a destination folder only")
+	}
+	// The charts already in a remote destination are not known, a plan would
+	// list them all as added
+	if dryRun && service.IsRemote(destination) {
+		logger.Printf("error: dry run applies to a destination folder only")
+		return errors.New("error: dry run applies to a destination folder only")
+	}
+	if planFormat != service.PlanText && planFormat != service.PlanJSON {
+		logger.Printf("error: not a valid plan format: `%s`", planFormat)
+		return errors.New("error: not a valid plan format")
+	}
+	if service.IsRemote(destination) {
+		// The charts are staged in a temporary folder before being pushed
+		folder, err = os.MkdirTemp("", "helm-mirror")
+		if err != nil {
+			logger.Printf("error: cannot create staging folder: %s", err)
+			return err
+		}
+		defer os.RemoveAll(folder)
+	} else {
+		folder = destination
+	}
+	// A dry run leaves the destination folder untouched
+	if !service.IsRemote(destination) && !dryRun {
+		err = os.MkdirAll(folder, 0744)
+		if err != nil {
+			logger.Printf("error: cannot create destination folder: %s", err)
+			return err
+		}
 	}
 
 	rootURL := &url.URL{}
@@ -162,11 +266,53 @@ func runRoot(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	if chartVersion != "" && chartName == "" {
+	if concurrency < 1 {
+		logger.Printf("error: concurrency has to be at least 1")
+		return errors.New("error: concurrency has to be at least 1")
+	}
+
+	if keepVersions < 0 {
+		logger.Printf("error: keep-versions cannot be negative")
+		return errors.New("error: keep-versions cannot be negative")
+	}
+
+	if includePre && excludePre {
+		logger.Printf("error: pre-releases cannot be both included and excluded")
+		return errors.New("error: pre-releases cannot be both included and excluded")
+	}
+
+	if chartVersion != "" && chartName == "" && repoURL.Scheme != registry.OCIScheme {
 		logger.Printf("error: chart Version depends on a chart name, please specify one")
 		return errors.New("error: chart Version depends on a chart name, please specify one")
 	}
 
+	var sinceTime, untilTime time.Time
+	now := time.Now()
+	if since != "" {
+		sinceTime, err = service.ParseTime(since, now)
+		if err != nil {
+			logger.Printf("error: since %s", err)
+			return err
+		}
+	}
+	if until != "" {
+		untilTime, err = service.ParseUntil(until, now)
+		if err != nil {
+			logger.Printf("error: until %s", err)
+			return err
+		}
+	}
+
+	includes := include
+	if includeFrom != "" {
+		patterns, err := service.ReadPatterns(includeFrom)
+		if err != nil {
+			logger.Printf("error: cannot read include patterns: %s", err)
+			return err
+		}
+		includes = append(append([]string{}, include...), patterns...)
+	}
+
 	config := repo.Entry{
 		Name:     folder,
 		URL:      repoURL.String(),
@@ -177,7 +323,41 @@ func runRoot(cmd *cobra.Command, args []string) error {
 		KeyFile:  keyFile,
 	}
 
-	getService := service.NewGetService(config, AllVersions, Verbose, IgnoreErrors, logger, rootURL.String(), chartName, chartVersion)
+	options := service.GetOptions{
+		Incremental:        incremental,
+		QuarantineDir:      quarantine,
+		Provenance:         prov,
+		Keyring:            keyring,
+		RequireSigned:      requireSign,
+		Concurrency:        concurrency,
+		Retries:            retries,
+		RetryBackoff:       retryBackoff,
+		VersionConstraint:  constraint,
+		IncludePrereleases: includePre,
+		ExcludePrereleases: excludePre,
+		KeepVersions:       keepVersions,
+		KeepVersionsPer:    keepPer,
+		Since:              sinceTime,
+		Until:              untilTime,
+		WithDependencies:   withDeps,
+		Prune:              prune,
+		PruneDryRun:        pruneDryRun,
+		DryRun:             dryRun,
+		PlanFormat:         planFormat,
+		RegenerateIndex:    regenIndex,
+		Include:            includes,
+		Exclude:            exclude,
+	}
+
+	getService := service.NewGetService(config, AllVersions, Verbose, IgnoreErrors, logger, rootURL.String(), chartName, chartVersion, options)
 	err = getService.Get()
+	if err != nil {
+		return err
+	}
+
+	if service.IsRemote(destination) {
+		pushService := service.NewPushService(folder, destination, destUsername, destPassword, Verbose, IgnoreErrors, logger)
+		err = pushService.Push()
+	}
 	return err
 }
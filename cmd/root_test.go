@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path"
@@ -10,6 +11,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/kplachkov/helm-mirror/fixtures"
+	"github.com/kplachkov/helm-mirror/service"
 )
 
 func Test_validateRootArgs(t *testing.T) {
@@ -37,6 +39,12 @@ func Test_validateRootArgs(t *testing.T) {
 		{"6", args{c, []string{"ftps://url", "/target", "extra"}}, true},
 		{"7", args{c, []string{"help"}}, false},
 		{"8", args{c, []string{"%", "/target", "extra"}}, true},
+		{"9.1", args{c, []string{"oci://registry/charts/chart", "/target"}}, false},
+		{"9.2", args{c, []string{"oci://registry/charts/chart", "target"}}, true},
+		{"10", args{c, []string{"https://url", "oci://registry/charts"}}, false},
+		{"11.1", args{c, []string{"https://url", "cm://chartmuseum"}}, false},
+		{"11.2", args{c, []string{"https://url", "cm+http://chartmuseum:8080"}}, false},
+		{"12", args{c, []string{"https://url", "s3://bucket/charts"}}, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -54,7 +62,7 @@ func Test_runRoot(t *testing.T) {
 	}
 	defer os.RemoveAll(dir)
 	svr := fixtures.StartHTTPServer()
-	defer svr.Shutdown(nil)
+	defer svr.Shutdown(context.Background())
 	fixtures.WaitForServer("http://127.0.0.1:1793/alive")
 	type args struct {
 		cmd          *cobra.Command
@@ -68,19 +76,31 @@ func Test_runRoot(t *testing.T) {
 	tests := []struct {
 		name    string
 		args    args
+		flags   func()
 		wantErr bool
 	}{
-		{"1", args{&cobra.Command{}, []string{"http://test", path.Join("/mr", "mzxyptlk")}, "", false, true, "", ""}, true},
-		{"2", args{&cobra.Command{}, []string{"http://127.0.0.1:1793", dir}, "", true, true, "", ""}, false},
-		{"3", args{&cobra.Command{}, []string{"%", dir}, "", false, true, "", ""}, true},
-		{"4", args{&cobra.Command{}, []string{"http://test", dir}, "%", false, true, "", ""}, true},
-		{"5", args{&cobra.Command{}, []string{"http://test", dir}, "ftp://test", false, true, "", ""}, true},
-		{"6", args{&cobra.Command{}, []string{"http://127.0.0.1:1793", dir}, "https://test/com/charts", true, true, "", ""}, false},
-		{"7", args{&cobra.Command{}, []string{"http://127.0.0.1:1111", dir}, "https://test/com/charts", false, true, "", ""}, true},
-		{"8", args{&cobra.Command{}, []string{"http://127.0.0.1:1793", dir}, "https://test/com/charts", true, true, "", ""}, false},
-		{"9", args{&cobra.Command{}, []string{"http://127.0.0.1:1793", dir}, "", false, true, "", ""}, true},
-		{"10", args{&cobra.Command{}, []string{"http://127.0.0.1:1793", dir}, "", true, false, "", ""}, false},
-		{"11", args{&cobra.Command{}, []string{"http://127.0.0.1:1793", dir}, "", true, false, "", "1.0.0"}, true},
+		{"1", args{&cobra.Command{}, []string{"http://test", path.Join("/mr", "mzxyptlk")}, "", false, true, "", ""}, nil, true},
+		{"2", args{&cobra.Command{}, []string{"http://127.0.0.1:1793", dir}, "", true, true, "", ""}, nil, false},
+		{"3", args{&cobra.Command{}, []string{"%", dir}, "", false, true, "", ""}, nil, true},
+		{"4", args{&cobra.Command{}, []string{"http://test", dir}, "%", false, true, "", ""}, nil, true},
+		{"5", args{&cobra.Command{}, []string{"http://test", dir}, "ftp://test", false, true, "", ""}, nil, true},
+		{"6", args{&cobra.Command{}, []string{"http://127.0.0.1:1793", dir}, "https://test/com/charts", true, true, "", ""}, nil, false},
+		{"7", args{&cobra.Command{}, []string{"http://127.0.0.1:1111", dir}, "https://test/com/charts", false, true, "", ""}, nil, true},
+		{"8", args{&cobra.Command{}, []string{"http://127.0.0.1:1793", dir}, "https://test/com/charts", true, true, "", ""}, nil, false},
+		{"9", args{&cobra.Command{}, []string{"http://127.0.0.1:1793", dir}, "", false, true, "", ""}, nil, true},
+		{"10", args{&cobra.Command{}, []string{"http://127.0.0.1:1793", dir}, "", true, false, "", ""}, nil, false},
+		{"11", args{&cobra.Command{}, []string{"http://127.0.0.1:1793", dir}, "", true, false, "", "1.0.0"}, nil, true},
+		{"12", args{&cobra.Command{}, []string{"http://127.0.0.1:1793", dir}, "", true, false, "", ""}, func() { includePre, excludePre = true, true }, true},
+		{"13", args{&cobra.Command{}, []string{"http://127.0.0.1:1793", dir}, "", true, false, "", ""}, func() { keepVersions = -1 }, true},
+		{"14", args{&cobra.Command{}, []string{"http://127.0.0.1:1793", dir}, "", true, false, "", ""}, func() { since = "yesterday" }, true},
+		{"15", args{&cobra.Command{}, []string{"http://127.0.0.1:1793", dir}, "", true, false, "", ""}, func() { planFormat = "yaml" }, true},
+		{"16.1", args{&cobra.Command{}, []string{"http://127.0.0.1:1793", "oci://127.0.0.1:5000/mirror"}, "", true, false, "", ""}, func() { prune = true }, true},
+		{"16.2", args{&cobra.Command{}, []string{"http://127.0.0.1:1793", "cm://127.0.0.1:8080"}, "", true, false, "", ""}, func() { prune = true }, true},
+		{"16.3", args{&cobra.Command{}, []string{"http://127.0.0.1:1793", "s3://bucket/charts"}, "", true, false, "", ""}, func() { prune = true }, true},
+		{"17.1", args{&cobra.Command{}, []string{"http://127.0.0.1:1793", "oci://127.0.0.1:5000/mirror"}, "", true, false, "", ""}, func() { dryRun = true }, true},
+		{"17.2", args{&cobra.Command{}, []string{"http://127.0.0.1:1793", "cm://127.0.0.1:8080"}, "", true, false, "", ""}, func() { dryRun = true }, true},
+		{"17.3", args{&cobra.Command{}, []string{"http://127.0.0.1:1793", "s3://bucket/charts"}, "", true, false, "", ""}, func() { dryRun = true }, true},
+		{"18", args{&cobra.Command{}, []string{"http://127.0.0.1:1793", dir}, "", true, false, "", ""}, func() { includeFrom = path.Join(dir, "missing-patterns") }, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -91,9 +111,25 @@ func Test_runRoot(t *testing.T) {
 			AllVersions = tt.args.allVersions
 			chartName = tt.args.chartName
 			chartVersion = tt.args.chartVersion
+			if tt.flags != nil {
+				t.Cleanup(resetRootFlags)
+				tt.flags()
+			}
 			if err := runRoot(tt.args.cmd, tt.args.args); (err != nil) != tt.wantErr {
 				t.Errorf("runRoot() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
+
+// resetRootFlags sets the flags changed by the rows of Test_runRoot back to
+// their defaults.
+func resetRootFlags() {
+	includePre, excludePre = false, false
+	keepVersions = 0
+	since = ""
+	planFormat = service.PlanText
+	prune = false
+	dryRun = false
+	includeFrom = ""
+}
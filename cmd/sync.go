@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/kplachkov/helm-mirror/service"
+)
+
+var configFile string
+
+const syncDesc = `Mirror all the repositories listed in a manifest file
+in a single run and report the outcome of each of them.
+Example:
+
+  - helm mirror sync --config /yourorg/mirror.yaml
+
+The manifest file lists the repositories with the same
+options as the mirror command. Credentials are referenced
+from environment variables or files. Example:
+
+	repositories:
+	- name: stable
+	  url: https://yourorg.com/charts
+	  destination: /yourorg/charts/stable
+	  newRootURL: https://mirror.local.lan/charts/stable
+	  credentials:
+	    usernameEnv: STABLE_USERNAME
+	    passwordFile: /yourorg/secrets/stable-password
+	  charts:
+	  - name: chart
+	  - name: chart2
+	    version: ~1.0
+	  incremental: true
+	  concurrency: 4
+	- name: platform
+	  url: oci://registry.yourorg.com/charts/platform
+	  destination: oci://registry.internal.lan/charts
+	  destinationCredentials:
+	    usernameEnv: INTERNAL_USERNAME
+	    passwordEnv: INTERNAL_PASSWORD
+	  allVersions: true
+
+A repository failing does not stop the others from
+being mirrored.
+
+Repositories sharing a destination folder are merged
+into a single chart repository. The conflictPolicy of
+the manifest, fail (default), first-wins or prefix,
+applies when two of them publish the same version of
+a chart.
+`
+
+// syncCmd represents the sync command
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Mirror all the repositories listed in a manifest file.",
+	Long:  syncDesc,
+	Args:  cobra.NoArgs,
+	RunE:  runSync,
+}
+
+func init() {
+	syncCmd.Flags().StringVarP(&configFile, "config", "c", "mirror.yaml", "manifest file listing the repositories to mirror")
+	rootCmd.AddCommand(syncCmd)
+}
+
+func runSync(cmd *cobra.Command, args []string) error {
+	manifest, err := service.LoadManifest(configFile)
+	if err != nil {
+		logger.Printf("error: %s", err)
+		return err
+	}
+
+	syncService := service.NewSyncService(manifest, Verbose, IgnoreErrors, logger)
+	return syncService.Sync()
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"errors"
+	"path"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kplachkov/helm-mirror/service"
+)
+
+var (
+	syncImagesTo          string
+	syncImagesConcurrency int
+	syncImagesInsecure    bool
+)
+
+const syncImagesDesc = `Copy the container images of the Helm Chart or the
+Helm Charts in the folder provided into a registry. Example:
+
+  - helm mirror sync-images /tmp/helm --to registry.internal/mirror
+  - helm mirror sync-images /tmp/helm/app.tgz --to registry.internal/mirror
+
+The images, as listed by inspect-images, are copied under the
+repository given with --to with their repository path and tag
+or digest: docker.io/library/nginx:1.25 is copied into
+registry.internal/mirror/library/nginx:1.25.
+
+The manifests are copied as published, so the images keep
+their digests, and a multi-arch image is copied with the
+images of all its platforms. An image already in the target
+registry with the same digest is skipped. A report lists the
+outcome of every image. The credentials of the registries are
+read from the Docker and Podman auth files.
+
+The [folder|tgzfile] has to be a full path.
+`
+
+// syncImagesCmd represents the sync-images command
+var syncImagesCmd = &cobra.Command{
+	Use:   "sync-images [folder|tgzfile]",
+	Short: "Copy all the container images listed in each chart into a registry.",
+	Long:  syncImagesDesc,
+	Args:  validateSyncImagesArgs,
+	RunE:  runSyncImages,
+}
+
+func init() {
+	syncImagesCmd.Flags().StringVar(&syncImagesTo, "to", "", "repository the images are copied under, like registry.internal/mirror (required)")
+	syncImagesCmd.Flags().IntVar(&syncImagesConcurrency, "concurrency", 1, "number of images copied in parallel")
+	syncImagesCmd.Flags().BoolVar(&syncImagesInsecure, "insecure-registries", false, "skip the TLS verification of the image registries, falling back to plain HTTP")
+	rootCmd.AddCommand(syncImagesCmd)
+}
+
+func validateSyncImagesArgs(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		logger.Print("error: requires at least one arg to execute")
+		return errors.New("error: requires at least one arg")
+	}
+	if !path.IsAbs(args[0]) {
+		logger.Printf("error: please provide a full path for [folder|tgzfile]: `%s`", args[0])
+		return errors.New("error: please provide a full path for [folder|tgzfile]")
+	}
+	if syncImagesTo == "" {
+		logger.Printf("error: please provide the target repository with --to")
+		return errors.New("error: please provide the target repository with --to")
+	}
+	if syncImagesConcurrency < 1 {
+		logger.Printf("error: concurrency has to be at least 1")
+		return errors.New("error: concurrency has to be at least 1")
+	}
+	return nil
+}
+
+func runSyncImages(cmd *cobra.Command, args []string) error {
+	options := service.SyncImagesOptions{
+		Concurrency:        syncImagesConcurrency,
+		InsecureRegistries: syncImagesInsecure,
+	}
+	syncImagesService := service.NewSyncImagesService(args[0], syncImagesTo, options, Verbose, IgnoreErrors, logger)
+	return syncImagesService.SyncImages()
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kplachkov/helm-mirror/service"
+)
+
+func Test_validateSyncImagesArgs(t *testing.T) {
+	c := &cobra.Command{}
+	tests := []struct {
+		name        string
+		args        []string
+		to          string
+		concurrency int
+		wantErr     bool
+	}{
+		{"1", []string{}, "registry.internal/mirror", 1, true},
+		{"2", []string{"folder"}, "registry.internal/mirror", 1, true},
+		{"3", []string{"/folder"}, "", 1, true},
+		{"4", []string{"/folder"}, "registry.internal/mirror", 0, true},
+		{"5", []string{"/folder"}, "registry.internal/mirror", 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			syncImagesTo, syncImagesConcurrency = tt.to, tt.concurrency
+			defer func() { syncImagesTo, syncImagesConcurrency = "", 1 }()
+			if err := validateSyncImagesArgs(c, tt.args); (err != nil) != tt.wantErr {
+				t.Errorf("validateSyncImagesArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_runSyncImages(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirror")
+	if err != nil {
+		t.Errorf("creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestRepository(t, dir)
+	tests := []struct {
+		name    string
+		target  string
+		to      string
+		wantErr bool
+	}{
+		{"1", dir, "registry.internal/mirror", !service.ImageCopySupported},
+		{"2", dir, "registry.internal/mirror:1.0", true},
+		{"3", path.Join(dir, "missing"), "registry.internal/mirror", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			syncImagesTo = tt.to
+			defer func() { syncImagesTo = "" }()
+			if err := runSyncImages(&cobra.Command{}, []string{tt.target}); (err != nil) != tt.wantErr {
+				t.Errorf("runSyncImages() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
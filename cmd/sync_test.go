@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kplachkov/helm-mirror/fixtures"
+)
+
+func Test_runSync(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirror")
+	if err != nil {
+		t.Errorf("creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	svr := fixtures.StartHTTPServer()
+	defer svr.Shutdown(context.Background())
+	fixtures.WaitForServer("http://127.0.0.1:1793/alive")
+	tests := []struct {
+		name     string
+		manifest string
+		wantErr  bool
+	}{
+		{"1", fmt.Sprintf("repositories:\n- name: local\n  url: http://127.0.0.1:1793\n  destination: %s\n", path.Join(dir, "local")), false},
+		{"2", fmt.Sprintf("repositories:\n- name: local\n  url: http://127.0.0.1:1111\n  destination: %s\n", path.Join(dir, "local")), true},
+		{"3", "repositories: []\n", true},
+		{"4", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configFile = path.Join(dir, "mirror.yaml")
+			if tt.manifest != "" {
+				err := os.WriteFile(configFile, []byte(tt.manifest), 0644)
+				if err != nil {
+					t.Fatalf("writing manifest: %s", err)
+				}
+			} else {
+				os.Remove(configFile)
+			}
+			IgnoreErrors = true
+			if err := runSync(&cobra.Command{}, []string{}); (err != nil) != tt.wantErr {
+				t.Errorf("runSync() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package fixtures
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+
+	"helm.sh/helm/v3/pkg/chart/loader"
+)
+
+// ChartMuseum is an in memory fake of the upload API of a ChartMuseum server
+// for tests. It requires basic authentication when a username is set.
+type ChartMuseum struct {
+	*httptest.Server
+	username string
+	password string
+	mu       sync.Mutex
+	charts   map[string]map[string][]byte
+	provs    map[string]map[string][]byte
+}
+
+// StartChartMuseum starts a fake ChartMuseum server, its API served under
+// /api/charts.
+func StartChartMuseum(username string, password string) *ChartMuseum {
+	cm := &ChartMuseum{
+		username: username,
+		password: password,
+		charts:   make(map[string]map[string][]byte),
+		provs:    make(map[string]map[string][]byte),
+	}
+	cm.Server = httptest.NewServer(http.HandlerFunc(cm.serve))
+	return cm
+}
+
+// Add stores a chart as if it was uploaded.
+func (c *ChartMuseum) Add(name string, version string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.charts[name] == nil {
+		c.charts[name] = make(map[string][]byte)
+	}
+	c.charts[name][version] = data
+}
+
+// Chart returns the archive and the provenance file of a chart version, nil
+// when they were not uploaded.
+func (c *ChartMuseum) Chart(name string, version string) ([]byte, []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.charts[name][version], c.provs[name][version]
+}
+
+func (c *ChartMuseum) serve(w http.ResponseWriter, r *http.Request) {
+	if c.username != "" {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != c.username || password != c.password {
+			writeChartMuseumError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+	}
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/api/charts":
+		c.upload(w, r)
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/charts/"):
+		c.versions(w, strings.TrimPrefix(r.URL.Path, "/api/charts/"))
+	default:
+		writeChartMuseumError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (c *ChartMuseum) versions(w http.ResponseWriter, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.charts[name]) == 0 {
+		writeChartMuseumError(w, http.StatusNotFound, "chart not found")
+		return
+	}
+	type chartVersion struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+	var list []chartVersion
+	for version := range c.charts[name] {
+		list = append(list, chartVersion{name, version})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	json.NewEncoder(w).Encode(list)
+}
+
+func (c *ChartMuseum) upload(w http.ResponseWriter, r *http.Request) {
+	files := make(map[string][]byte)
+	for _, field := range []string{"chart", "prov"} {
+		f, _, err := r.FormFile(field)
+		if err != nil {
+			continue
+		}
+		files[field], _ = io.ReadAll(f)
+		f.Close()
+	}
+	if files["chart"] == nil {
+		writeChartMuseumError(w, http.StatusInternalServerError, "no chart in the upload")
+		return
+	}
+	ch, err := loader.LoadArchive(bytes.NewReader(files["chart"]))
+	if err != nil {
+		writeChartMuseumError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	name, version := ch.Metadata.Name, ch.Metadata.Version
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.charts[name][version] != nil {
+		writeChartMuseumError(w, http.StatusConflict, "file already exists")
+		return
+	}
+	if c.charts[name] == nil {
+		c.charts[name] = make(map[string][]byte)
+		c.provs[name] = make(map[string][]byte)
+	}
+	c.charts[name][version] = files["chart"]
+	if files["prov"] != nil {
+		if c.provs[name] == nil {
+			c.provs[name] = make(map[string][]byte)
+		}
+		c.provs[name][version] = files["prov"]
+	}
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(`{"saved":true}`))
+}
+
+func writeChartMuseumError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,218 @@
+package fixtures
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/opencontainers/go-digest"
+	imgspec "github.com/opencontainers/image-spec/specs-go"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// PushImage pushes an OCI image made of the layers into the repository of
+// the registry for tests, and returns the descriptor of its manifest. Every
+// layer is a gzipped tar holding a single file with the content.
+func PushImage(host string, repository string, tag string, layers ...[]byte) (imgspecv1.Descriptor, error) {
+	return pushImage(host, repository, tag, ociMediaTypes, layers)
+}
+
+// PushDockerImage pushes an image like PushImage, with the media types of
+// a Docker image manifest.
+func PushDockerImage(host string, repository string, tag string, layers ...[]byte) (imgspecv1.Descriptor, error) {
+	return pushImage(host, repository, tag, dockerMediaTypes, layers)
+}
+
+// PushIndex pushes an OCI image index of the manifests, already pushed into
+// the repository, and returns the descriptor of the index.
+func PushIndex(host string, repository string, tag string, manifests ...imgspecv1.Descriptor) (imgspecv1.Descriptor, error) {
+	return pushIndex(host, repository, tag, ociMediaTypes, manifests)
+}
+
+// PushDockerList pushes a Docker manifest list of the manifests like
+// PushIndex.
+func PushDockerList(host string, repository string, tag string, manifests ...imgspecv1.Descriptor) (imgspecv1.Descriptor, error) {
+	return pushIndex(host, repository, tag, dockerMediaTypes, manifests)
+}
+
+// mediaTypes are the media types of the manifests pushed.
+type mediaTypes struct {
+	manifest string
+	index    string
+	config   string
+	layer    string
+}
+
+var ociMediaTypes = mediaTypes{
+	manifest: imgspecv1.MediaTypeImageManifest,
+	index:    imgspecv1.MediaTypeImageIndex,
+	config:   imgspecv1.MediaTypeImageConfig,
+	layer:    imgspecv1.MediaTypeImageLayerGzip,
+}
+
+var dockerMediaTypes = mediaTypes{
+	manifest: manifest.DockerV2Schema2MediaType,
+	index:    manifest.DockerV2ListMediaType,
+	config:   manifest.DockerV2Schema2ConfigMediaType,
+	layer:    manifest.DockerV2Schema2LayerMediaType,
+}
+
+func pushImage(host string, repository string, tag string, types mediaTypes, layers [][]byte) (imgspecv1.Descriptor, error) {
+	image := imgspecv1.Image{
+		Architecture: "amd64",
+		OS:           "linux",
+		RootFS:       imgspecv1.RootFS{Type: "layers"},
+	}
+	var blobs [][]byte
+	var descriptors []imgspecv1.Descriptor
+	for _, content := range layers {
+		layer, diffID, err := layerBlob(content)
+		if err != nil {
+			return imgspecv1.Descriptor{}, err
+		}
+		image.RootFS.DiffIDs = append(image.RootFS.DiffIDs, diffID)
+		blobs = append(blobs, layer)
+		descriptors = append(descriptors, imgspecv1.Descriptor{MediaType: types.layer, Digest: digest.FromBytes(layer), Size: int64(len(layer))})
+	}
+	config, err := json.Marshal(image)
+	if err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	m := imgspecv1.Manifest{
+		Versioned: imgspec.Versioned{SchemaVersion: 2},
+		MediaType: types.manifest,
+		Config:    imgspecv1.Descriptor{MediaType: types.config, Digest: digest.FromBytes(config), Size: int64(len(config))},
+		Layers:    descriptors,
+	}
+	for _, blob := range append([][]byte{config}, blobs...) {
+		err = pushBlob(host, repository, blob)
+		if err != nil {
+			return imgspecv1.Descriptor{}, err
+		}
+	}
+	return pushManifest(host, repository, tag, types.manifest, m)
+}
+
+func pushIndex(host string, repository string, tag string, types mediaTypes, manifests []imgspecv1.Descriptor) (imgspecv1.Descriptor, error) {
+	index := imgspecv1.Index{
+		Versioned: imgspec.Versioned{SchemaVersion: 2},
+		MediaType: types.index,
+		Manifests: manifests,
+	}
+	return pushManifest(host, repository, tag, types.index, index)
+}
+
+// layerBlob returns a gzipped tar holding a single file with the content,
+// and the digest of the tar. The gzip header has no time, so that the
+// same content always gives the same layer.
+func layerBlob(content []byte) ([]byte, digest.Digest, error) {
+	var tarred bytes.Buffer
+	tw := tar.NewWriter(&tarred)
+	err := tw.WriteHeader(&tar.Header{Name: "content", Mode: 0644, Size: int64(len(content))})
+	if err != nil {
+		return nil, "", err
+	}
+	_, err = tw.Write(content)
+	if err != nil {
+		return nil, "", err
+	}
+	err = tw.Close()
+	if err != nil {
+		return nil, "", err
+	}
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	_, err = gw.Write(tarred.Bytes())
+	if err != nil {
+		return nil, "", err
+	}
+	err = gw.Close()
+	if err != nil {
+		return nil, "", err
+	}
+	return gzipped.Bytes(), digest.FromBytes(tarred.Bytes()), nil
+}
+
+// ManifestDigest returns the digest of the manifest of the reference, a tag
+// or a digest, in the repository of the registry.
+func ManifestDigest(host string, repository string, ref string) (digest.Digest, error) {
+	req, err := http.NewRequest(http.MethodHead, fmt.Sprintf("http://%s/v2/%s/manifests/%s", host, repository, ref), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", strings.Join([]string{
+		imgspecv1.MediaTypeImageManifest, imgspecv1.MediaTypeImageIndex,
+		manifest.DockerV2Schema2MediaType, manifest.DockerV2ListMediaType,
+	}, ", "))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("%s %s: %s", req.Method, req.URL.Path, resp.Status)
+	}
+	return digest.Parse(resp.Header.Get("Docker-Content-Digest"))
+}
+
+// pushManifest uploads a manifest under the tag.
+func pushManifest(host string, repository string, tag string, mediaType string, m interface{}) (imgspecv1.Descriptor, error) {
+	content, err := json.Marshal(m)
+	if err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://%s/v2/%s/manifests/%s", host, repository, tag), bytes.NewReader(content))
+	if err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	req.Header.Set("Content-Type", mediaType)
+	err = doRegistryRequest(req, http.StatusCreated)
+	if err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	return imgspecv1.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(content), Size: int64(len(content))}, nil
+}
+
+// pushBlob uploads a blob in a single request.
+func pushBlob(host string, repository string, blob []byte) error {
+	resp, err := http.Post(fmt.Sprintf("http://%s/v2/%s/blobs/uploads/", host, repository), "", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return errors.Errorf("starting upload: %s", resp.Status)
+	}
+	location, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return err
+	}
+	query := location.Query()
+	query.Set("digest", digest.FromBytes(blob).String())
+	location.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodPut, location.String(), bytes.NewReader(blob))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	return doRegistryRequest(req, http.StatusCreated)
+}
+
+func doRegistryRequest(req *http.Request, status int) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != status {
+		return errors.Errorf("%s %s: %s", req.Method, req.URL.Path, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package fixtures
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/inmemory" // in memory storage for the test registry
+	"github.com/pkg/errors"
+)
+
+// StartRegistry starts an OCI registry for tests with an in memory storage,
+// and returns its host.
+func StartRegistry() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	config := &configuration.Configuration{}
+	config.HTTP.Addr = fmt.Sprintf("127.0.0.1:%d", port)
+	config.HTTP.DrainTimeout = 10 * time.Second
+	config.Storage = map[string]configuration.Parameters{"inmemory": map[string]interface{}{}}
+	config.Log.Level = "error"
+	r, err := registry.NewRegistry(context.Background(), config)
+	if err != nil {
+		return "", err
+	}
+	go r.ListenAndServe()
+
+	host := fmt.Sprintf("localhost:%d", port)
+	err = WaitForServer(fmt.Sprintf("http://%s/v2/", host))
+	if err != nil {
+		return "", errors.Wrap(err, "test registry not available")
+	}
+	return host, nil
+}
@@ -0,0 +1,262 @@
+package fixtures
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// S3 is an in memory fake of the S3 API for tests, standing in for a local
+// MinIO server. It serves path-style requests to list, read and write the
+// objects of its buckets, with their user metadata.
+type S3 struct {
+	*httptest.Server
+	mu      sync.Mutex
+	buckets map[string]map[string]*s3Entry
+}
+
+// s3Entry is a stored object.
+type s3Entry struct {
+	data     []byte
+	etag     string
+	metadata http.Header
+}
+
+// StartS3 starts a fake S3 server with the buckets.
+func StartS3(buckets ...string) *S3 {
+	s := &S3{buckets: make(map[string]map[string]*s3Entry)}
+	for _, b := range buckets {
+		s.buckets[b] = make(map[string]*s3Entry)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Put stores an object as if it was uploaded at once.
+func (s *S3) Put(bucket string, key string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buckets[bucket][key] = &s3Entry{data: data, etag: etag(data)}
+}
+
+// PutMultipart stores an object as if it was uploaded in parts, with an ETag
+// that is not the MD5 of its content, and the user metadata.
+func (s *S3) PutMultipart(bucket string, key string, data []byte, metadata map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	header := make(http.Header)
+	for k, v := range metadata {
+		header.Set("X-Amz-Meta-"+k, v)
+	}
+	sum := md5.Sum(data)
+	s.buckets[bucket][key] = &s3Entry{data: data, etag: fmt.Sprintf(`"%s-2"`, hex.EncodeToString(sum[:])), metadata: header}
+}
+
+// Object returns the content of an object, nil when it does not exist.
+func (s *S3) Object(bucket string, key string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if o, ok := s.buckets[bucket][key]; ok {
+		return o.data
+	}
+	return nil
+}
+
+// Metadata returns the user metadata of an object, nil when it does not exist.
+func (s *S3) Metadata(bucket string, key string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.buckets[bucket][key]
+	if !ok {
+		return nil
+	}
+	metadata := make(map[string]string)
+	for k := range o.metadata {
+		metadata[strings.ToLower(strings.TrimPrefix(k, "X-Amz-Meta-"))] = o.metadata.Get(k)
+	}
+	return metadata
+}
+
+// Keys returns the sorted keys of the objects of a bucket.
+func (s *S3) Keys(bucket string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for k := range s.buckets[bucket] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *S3) serve(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	objects, ok := s.buckets[parts[0]]
+	if !ok {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+
+	if len(parts) == 1 || parts[1] == "" {
+		if r.Method != http.MethodGet {
+			writeS3Error(w, http.StatusNotImplemented, "NotImplemented", "not implemented")
+			return
+		}
+		s.list(w, r, parts[0], objects)
+		return
+	}
+
+	key := parts[1]
+	switch r.Method {
+	case http.MethodPut:
+		data, err := readS3Body(r)
+		if err != nil {
+			writeS3Error(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+			return
+		}
+		metadata := make(http.Header)
+		for k, v := range r.Header {
+			if strings.HasPrefix(k, "X-Amz-Meta-") {
+				metadata[k] = v
+			}
+		}
+		objects[key] = &s3Entry{data: data, etag: etag(data), metadata: metadata}
+		w.Header().Set("ETag", etag(data))
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet, http.MethodHead:
+		o, ok := objects[key]
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
+			return
+		}
+		for k, v := range o.metadata {
+			w.Header()[k] = v
+		}
+		w.Header().Set("ETag", o.etag)
+		w.Header().Set("Content-Length", strconv.Itoa(len(o.data)))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(o.data)
+		}
+	default:
+		writeS3Error(w, http.StatusNotImplemented, "NotImplemented", "not implemented")
+	}
+}
+
+type s3Object struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int
+	StorageClass string
+}
+
+type s3Prefix struct {
+	Prefix string
+}
+
+type s3ListResult struct {
+	XMLName        xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name           string
+	Prefix         string
+	Delimiter      string
+	KeyCount       int
+	MaxKeys        int
+	IsTruncated    bool
+	Contents       []s3Object
+	CommonPrefixes []s3Prefix
+}
+
+// list answers a ListObjectsV2 request, all the keys in a single page.
+func (s *S3) list(w http.ResponseWriter, r *http.Request, bucket string, objects map[string]*s3Entry) {
+	prefix := r.URL.Query().Get("prefix")
+	delimiter := r.URL.Query().Get("delimiter")
+	result := s3ListResult{Name: bucket, Prefix: prefix, Delimiter: delimiter, MaxKeys: 1000}
+
+	var keys []string
+	for k := range objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	prefixes := make(map[string]bool)
+	for _, k := range keys {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		if delimiter != "" {
+			if i := strings.Index(k[len(prefix):], delimiter); i >= 0 {
+				p := k[:len(prefix)+i+len(delimiter)]
+				if !prefixes[p] {
+					prefixes[p] = true
+					result.CommonPrefixes = append(result.CommonPrefixes, s3Prefix{p})
+				}
+				continue
+			}
+		}
+		result.Contents = append(result.Contents, s3Object{
+			Key:          k,
+			LastModified: time.Now().UTC().Format(time.RFC3339),
+			ETag:         objects[k].etag,
+			Size:         len(objects[k].data),
+			StorageClass: "STANDARD",
+		})
+	}
+	result.KeyCount = len(result.Contents) + len(result.CommonPrefixes)
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(result)
+}
+
+// readS3Body returns the content of an upload, decoding the chunks of a
+// streaming signature.
+func readS3Body(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+
+	var data []byte
+	reader := bufio.NewReader(r.Body)
+	for {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.ParseInt(strings.SplitN(strings.TrimSpace(header), ";", 2)[0], 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return data, nil
+		}
+		chunk := make([]byte, size+2)
+		_, err = io.ReadFull(reader, chunk)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, chunk[:size]...)
+	}
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return fmt.Sprintf("%q", hex.EncodeToString(sum[:]))
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s<Error><Code>%s</Code><Message>%s</Message></Error>", xml.Header, code, message)
+}
@@ -10,7 +10,7 @@ mkdir "$workdir"
 
 for d in $(go list ./... | grep -v -E "vendor|fixtures"); do
     f="$workdir/$(echo $d | tr / -).cover"
-    go test -covermode="$mode" -coverprofile="$f" $d
+    go test -tags containers_image_openpgp -covermode="$mode" -coverprofile="$f" $d
 done
 
 echo "mode: $mode" >"$profile"
@@ -0,0 +1,258 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chart/loader"
+)
+
+// Schemes of a ChartMuseum destination, served over HTTPS or plain HTTP
+const (
+	ChartMuseumScheme     = "cm"
+	ChartMuseumHTTPScheme = "cm+http"
+)
+
+// IsChartMuseum returns true if the reference is a ChartMuseum destination.
+func IsChartMuseum(ref string) bool {
+	return strings.HasPrefix(ref, ChartMuseumScheme+"://") || strings.HasPrefix(ref, ChartMuseumHTTPScheme+"://")
+}
+
+// ChartMuseumPushService structure definition
+type ChartMuseumPushService struct {
+	pushSummary
+	folder       string
+	target       string
+	username     string
+	password     string
+	verbose      bool
+	ignoreErrors bool
+	logger       *log.Logger
+	client       *http.Client
+}
+
+// NewChartMuseumPushService return a new instance of ChartMuseumPushService
+func NewChartMuseumPushService(folder string, target string, username string, password string, verbose bool, ignoreErrors bool, logger *log.Logger) PushServiceInterface {
+	return &ChartMuseumPushService{
+		folder:       folder,
+		target:       target,
+		username:     username,
+		password:     password,
+		verbose:      verbose,
+		ignoreErrors: ignoreErrors,
+		logger:       logger,
+		client:       http.DefaultClient,
+	}
+}
+
+// Push uploads every chart of the folder, with its provenance file, to the
+// upload API of the ChartMuseum server. The versions already on the server
+// are skipped.
+func (c *ChartMuseumPushService) Push() error {
+	api, err := chartMuseumAPI(c.target)
+	if err != nil {
+		c.logger.Printf("error: %s", err)
+		return err
+	}
+
+	charts, err := filepath.Glob(path.Join(c.folder, "*.tgz"))
+	if err != nil {
+		return err
+	}
+
+	versions := make(map[string][]string)
+	for _, chartPath := range charts {
+		err = c.pushChart(api, chartPath, versions)
+		if err != nil {
+			if c.ignoreErrors {
+				c.logger.Printf("WARNING: pushing chart %s - %s", path.Base(chartPath), err)
+				c.failed++
+				continue
+			}
+			return err
+		}
+	}
+
+	c.logger.Printf("uploads: %d pushed, %d skipped, %d failed", c.pushed, c.skipped, c.failed)
+	return nil
+}
+
+// chartMuseumAPI returns the URL of the charts API of a ChartMuseum
+// destination, cm://host/context-path being served over HTTPS.
+func chartMuseumAPI(target string) (string, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", errors.Wrap(err, "not a valid ChartMuseum destination")
+	}
+	switch u.Scheme {
+	case ChartMuseumScheme:
+		u.Scheme = "https"
+	case ChartMuseumHTTPScheme:
+		u.Scheme = "http"
+	default:
+		return "", errors.Errorf("not a valid ChartMuseum destination: `%s`", target)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/api/charts"
+	return u.String(), nil
+}
+
+// pushChart uploads a chart unless its version is already on the server.
+// versions caches the versions of the charts already queried.
+func (c *ChartMuseumPushService) pushChart(api string, chartPath string, versions map[string][]string) error {
+	data, err := os.ReadFile(chartPath)
+	if err != nil {
+		return err
+	}
+	ch, err := loader.LoadArchive(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	name, version := ch.Metadata.Name, ch.Metadata.Version
+	if _, ok := versions[name]; !ok {
+		versions[name], err = c.chartVersions(api, name)
+		if err != nil {
+			return err
+		}
+	}
+	for _, v := range versions[name] {
+		if v == version {
+			if c.verbose {
+				c.logger.Printf("skipping %s(%s): already on the server", name, version)
+			}
+			c.skipped++
+			return nil
+		}
+	}
+
+	prov, err := os.ReadFile(chartPath + provenanceExtension)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	status, err := c.upload(api, path.Base(chartPath), data, prov)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusConflict {
+		// Uploaded since the versions were listed
+		if c.verbose {
+			c.logger.Printf("skipping %s(%s): already on the server", name, version)
+		}
+		c.skipped++
+		return nil
+	}
+	c.logger.Printf("pushed %s(%s)", name, version)
+	c.pushed++
+	return nil
+}
+
+// chartVersions returns the versions of a chart on the server, none when the
+// server does not know the chart.
+func (c *ChartMuseumPushService) chartVersions(api string, name string) ([]string, error) {
+	req, err := http.NewRequest(http.MethodGet, api+"/"+url.PathEscape(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, chartMuseumError(resp)
+	}
+
+	var charts []struct {
+		Version string `json:"version"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&charts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot decode the versions of chart %s", name)
+	}
+	list := make([]string, 0, len(charts))
+	for _, ch := range charts {
+		list = append(list, ch.Version)
+	}
+	return list, nil
+}
+
+// upload posts a chart, and its provenance file when there is one, as a
+// multipart form. It returns the status of a successful or conflicting upload.
+func (c *ChartMuseumPushService) upload(api string, chartFileName string, data []byte, prov []byte) (int, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	files := []struct {
+		field   string
+		name    string
+		content []byte
+	}{
+		{"chart", chartFileName, data},
+		{"prov", chartFileName + provenanceExtension, prov},
+	}
+	for _, f := range files {
+		if f.content == nil {
+			continue
+		}
+		part, err := form.CreateFormFile(f.field, f.name)
+		if err != nil {
+			return 0, err
+		}
+		_, err = part.Write(f.content)
+		if err != nil {
+			return 0, err
+		}
+	}
+	err := form.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, api, &body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	resp, err := c.do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusConflict {
+		return 0, chartMuseumError(resp)
+	}
+	return resp.StatusCode, nil
+}
+
+// do sends a request to the server with the credentials of the destination.
+func (c *ChartMuseumPushService) do(req *http.Request) (*http.Response, error) {
+	if c.username != "" || c.password != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	return c.client.Do(req)
+}
+
+// chartMuseumError returns the error of a failed request, with the message
+// sent by the server.
+func chartMuseumError(resp *http.Response) error {
+	var message struct {
+		Error string `json:"error"`
+	}
+	content, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if json.Unmarshal(content, &message) == nil && message.Error != "" {
+		return errors.Errorf("%s %s: %s: %s", resp.Request.Method, resp.Request.URL, resp.Status, message.Error)
+	}
+	return errors.Errorf("%s %s: %s", resp.Request.Method, resp.Request.URL, resp.Status)
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kplachkov/helm-mirror/fixtures"
+)
+
+func TestNewChartMuseumPushService(t *testing.T) {
+	want := &ChartMuseumPushService{folder: "/folder", target: "cm://chartmuseum", logger: fakeLogger, client: http.DefaultClient}
+	if got := NewChartMuseumPushService("/folder", "cm://chartmuseum", "", "", false, false, fakeLogger); !reflect.DeepEqual(got, want) {
+		t.Errorf("NewChartMuseumPushService() = %v, want %v", got, want)
+	}
+}
+
+func Test_chartMuseumAPI(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		want    string
+		wantErr bool
+	}{
+		{"1", "cm://chartmuseum.local", "https://chartmuseum.local/api/charts", false},
+		{"2", "cm+http://chartmuseum.local:8080/", "http://chartmuseum.local:8080/api/charts", false},
+		{"3", "cm://chartmuseum.local/context/", "https://chartmuseum.local/context/api/charts", false},
+		{"4", "oci://registry.local/charts", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := chartMuseumAPI(tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("chartMuseumAPI() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("chartMuseumAPI() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChartMuseumPushService_Push(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	cm := fixtures.StartChartMuseum("user", "secret")
+	defer cm.Close()
+	target := strings.Replace(cm.URL, "http://", "cm+http://", 1)
+
+	folder := path.Join(dir, "folder")
+	os.MkdirAll(folder, 0744)
+	signedPath := packageTestChart(t, folder, "signed", "1.0.0")
+	os.WriteFile(signedPath+provenanceExtension, []byte("provenance"), 0644)
+	packageTestChart(t, folder, "plain", "1.0.0")
+	packageTestChart(t, folder, "plain", "1.1.0")
+	packageTestChart(t, folder, "present", "1.0.0")
+	cm.Add("present", "1.0.0", []byte("uploaded before"))
+
+	errorFolder := path.Join(dir, "error")
+	os.MkdirAll(errorFolder, 0744)
+	os.WriteFile(path.Join(errorFolder, "broken-1.0.0.tgz"), []byte("not a chart"), 0644)
+
+	tests := []struct {
+		name         string
+		folder       string
+		password     string
+		ignoreErrors bool
+		wantErr      bool
+		want         pushSummary
+	}{
+		{"1", folder, "wrong", false, true, pushSummary{}},
+		{"2", folder, "wrong", true, false, pushSummary{failed: 4}},
+		{"3", folder, "secret", false, false, pushSummary{pushed: 3, skipped: 1}},
+		{"4", folder, "secret", false, false, pushSummary{skipped: 4}},
+		{"5", errorFolder, "secret", false, true, pushSummary{}},
+		{"6", errorFolder, "secret", true, false, pushSummary{failed: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewChartMuseumPushService(tt.folder, target, "user", tt.password, false, tt.ignoreErrors, fakeLogger).(*ChartMuseumPushService)
+			if err := c.Push(); (err != nil) != tt.wantErr {
+				t.Errorf("ChartMuseumPushService.Push() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if c.pushSummary != tt.want {
+				t.Errorf("ChartMuseumPushService.Push() = %s, want %s", c.pushSummary, tt.want)
+			}
+		})
+	}
+
+	signed, _ := os.ReadFile(signedPath)
+	data, prov := cm.Chart("signed", "1.0.0")
+	if !reflect.DeepEqual(data, signed) || string(prov) != "provenance" {
+		t.Errorf("ChartMuseumPushService.Push() uploaded %d bytes and provenance %q", len(data), prov)
+	}
+	if data, _ := cm.Chart("present", "1.0.0"); string(data) != "uploaded before" {
+		t.Errorf("ChartMuseumPushService.Push() replaced a chart already on the server")
+	}
+}
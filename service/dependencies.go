@@ -0,0 +1,369 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/provenance"
+	"helm.sh/helm/v3/pkg/registry"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// requiredByAnnotation lists, in the index file, the charts that required a
+// mirrored dependency
+const requiredByAnnotation = "helm-mirror/required-by"
+
+// dependencySource is a repository the dependencies of the charts are
+// mirrored from
+type dependencySource struct {
+	url       string
+	service   *GetService
+	chartRepo *repo.ChartRepository
+	client    *registry.Client
+	cleanup   func()
+}
+
+// requiredChart is a dependency chart to mirror and the repository it comes from
+type requiredChart struct {
+	chart  *repo.ChartVersion
+	source *dependencySource
+}
+
+// chartKey identifies a version of a chart.
+func chartKey(chart *repo.ChartVersion) string {
+	return fmt.Sprintf("%s-%s", chart.Name, chart.Version)
+}
+
+// mirrorDependencies mirrors, from their own repositories, the dependencies
+// of the mirrored charts that are not packaged within them, and then their
+// own dependencies until none is left. It returns the mirrored dependencies
+// and, for every one of them, the charts that required it. The dependencies
+// of the selected charts that failed, and those that cannot be resolved, are
+// kept from pruning.
+func (g *GetService) mirrorDependencies(selected []*repo.ChartVersion, charts []*repo.ChartVersion) ([]*repo.ChartVersion, map[string][]string, error) {
+	sources := make(map[string]*dependencySource)
+	defer func() {
+		for _, source := range sources {
+			source.cleanup()
+		}
+	}()
+
+	known := make(map[string]bool)
+	for _, c := range charts {
+		known[chartKey(c)] = true
+	}
+	requiredBy := make(map[string][]string)
+	g.keepLocalDependencies(failedCharts(selected, charts))
+
+	var total summary
+	var dependencies []*repo.ChartVersion
+	for pending := charts; len(pending) > 0; {
+		required, err := g.resolveDependencies(pending, sources, known, requiredBy)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// The dependencies are mirrored by repository, in the order they were found
+		var order []*dependencySource
+		bySource := make(map[*dependencySource][]*repo.ChartVersion)
+		for _, r := range required {
+			if _, ok := bySource[r.source]; !ok {
+				order = append(order, r.source)
+			}
+			bySource[r.source] = append(bySource[r.source], r.chart)
+		}
+
+		pending = nil
+		for _, source := range order {
+			source.service.summary = summary{}
+			mirrored, err := source.service.mirrorCharts(bySource[source], source.mirror)
+			total.add(source.service.summary)
+			if err != nil {
+				return nil, nil, err
+			}
+			g.keepLocalDependencies(failedCharts(bySource[source], mirrored))
+			pending = append(pending, mirrored...)
+			dependencies = append(dependencies, mirrored...)
+		}
+	}
+
+	g.logger.Printf("dependencies: %s", total)
+	return dependencies, requiredBy, nil
+}
+
+// resolveDependencies returns the dependencies of the charts that are not
+// known yet, and records the charts requiring every dependency.
+func (g *GetService) resolveDependencies(charts []*repo.ChartVersion, sources map[string]*dependencySource, known map[string]bool, requiredBy map[string][]string) ([]requiredChart, error) {
+	var required []requiredChart
+	for _, parent := range charts {
+		parentKey := chartKey(parent)
+		c, err := loader.Load(path.Join(g.config.Name, parentKey+".tgz"))
+		if err != nil {
+			err = g.dependencyError(parent, errors.Wrap(err, "cannot load chart"))
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		for _, dep := range c.Metadata.Dependencies {
+			if packaged(c, dep) {
+				if g.verbose {
+					g.logger.Printf("dependency %s(%s) of %s is packaged within the chart", dep.Name, dep.Version, parentKey)
+				}
+				continue
+			}
+
+			dependency, source, err := g.resolveDependency(dep, sources)
+			if err != nil {
+				err = g.dependencyError(parent, errors.Wrapf(err, "dependency %s(%s)", dep.Name, dep.Version))
+				if err != nil {
+					return nil, err
+				}
+				g.keepDependency(dep.Name)
+				continue
+			}
+
+			key := chartKey(dependency)
+			requiredBy[key] = append(requiredBy[key], parentKey)
+			if known[key] {
+				continue
+			}
+			known[key] = true
+			g.logger.Printf("dependency %s(%s) from %s required by %s", dependency.Name, dependency.Version, source.url, parentKey)
+			required = append(required, requiredChart{chart: dependency, source: source})
+		}
+	}
+	return required, nil
+}
+
+// resolveDependency returns the newest version of the dependency matching its
+// version range, and the repository it comes from.
+func (g *GetService) resolveDependency(dep *chart.Dependency, sources map[string]*dependencySource) (*repo.ChartVersion, *dependencySource, error) {
+	repoURL, err := dependencyRepository(dep.Repository)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	source, ok := sources[repoURL]
+	if !ok {
+		source, err = g.newDependencySource(repoURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		sources[repoURL] = source
+	}
+
+	dependency, err := source.resolve(dep.Name, dep.Version)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dependency, source, nil
+}
+
+// failedCharts returns the charts that are not among the mirrored ones.
+func failedCharts(charts []*repo.ChartVersion, mirrored []*repo.ChartVersion) []*repo.ChartVersion {
+	done := make(map[string]bool)
+	for _, c := range mirrored {
+		done[chartKey(c)] = true
+	}
+	var failed []*repo.ChartVersion
+	for _, c := range charts {
+		if !done[chartKey(c)] {
+			failed = append(failed, c)
+		}
+	}
+	return failed
+}
+
+// keepLocalDependencies keeps from pruning the dependencies of the charts,
+// read from their copies in the destination folder. The charts without a
+// copy have nothing to keep.
+func (g *GetService) keepLocalDependencies(charts []*repo.ChartVersion) {
+	for _, c := range charts {
+		loaded, err := loader.Load(path.Join(g.config.Name, chartKey(c)+".tgz"))
+		if err != nil {
+			continue
+		}
+		for _, dep := range loaded.Metadata.Dependencies {
+			if !packaged(loaded, dep) {
+				g.keepDependency(dep.Name)
+			}
+		}
+	}
+}
+
+// keepDependency keeps every version of the dependency in the destination
+// folder from pruning.
+func (g *GetService) keepDependency(name string) {
+	if g.keptDependencies == nil {
+		g.keptDependencies = make(map[string]bool)
+	}
+	if !g.keptDependencies[name] && g.verbose {
+		g.logger.Printf("keeping the versions of dependency %s in the folder", name)
+	}
+	g.keptDependencies[name] = true
+}
+
+// dependencyError reports the failure to mirror the dependencies of a chart,
+// only as a warning when errors are ignored.
+func (g *GetService) dependencyError(parent *repo.ChartVersion, err error) error {
+	if g.ignoreErrors {
+		g.logger.Printf("WARNING: processing dependencies of chart %s(%s) - %s", parent.Name, parent.Version, err)
+		return nil
+	}
+	return errors.Wrapf(err, "chart %s(%s)", parent.Name, parent.Version)
+}
+
+// packaged reports whether the dependency is packaged within the chart.
+func packaged(c *chart.Chart, dep *chart.Dependency) bool {
+	for _, sub := range c.Dependencies() {
+		if sub.Name() == dep.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// dependencyRepository returns the URL of the repository of a dependency,
+// resolving the repository names of the Helm configuration (@name or
+// alias:name).
+func dependencyRepository(repository string) (string, error) {
+	var name string
+	switch {
+	case repository == "" || strings.HasPrefix(repository, "file://"):
+		return "", errors.New("local dependency not packaged within the chart")
+	case strings.HasPrefix(repository, "@"):
+		name = strings.TrimPrefix(repository, "@")
+	case strings.HasPrefix(repository, "alias:"):
+		name = strings.TrimPrefix(repository, "alias:")
+	default:
+		return strings.TrimSuffix(repository, "/"), nil
+	}
+
+	repositories, err := repo.LoadFile(cli.New().RepositoryConfig)
+	if err != nil {
+		return "", errors.Wrapf(err, "cannot resolve repository %s", repository)
+	}
+	entry := repositories.Get(name)
+	if entry == nil {
+		return "", errors.Errorf("repository %s not found in the Helm configuration", repository)
+	}
+	return strings.TrimSuffix(entry.URL, "/"), nil
+}
+
+// newDependencySource returns the dependency repository at repoURL. Its charts
+// are mirrored with the options of the service, into the same folder. The
+// credentials of the service are not sent to the other repositories.
+func (g *GetService) newDependencySource(repoURL string) (*dependencySource, error) {
+	service := *g
+	service.config = repo.Entry{
+		Name:                  g.config.Name,
+		URL:                   repoURL,
+		CAFile:                g.config.CAFile,
+		CertFile:              g.config.CertFile,
+		KeyFile:               g.config.KeyFile,
+		InsecureSkipTLSverify: g.config.InsecureSkipTLSverify,
+	}
+	source := &dependencySource{url: repoURL, service: &service, cleanup: func() {}}
+
+	var err error
+	if registry.IsOCI(repoURL) {
+		ref := strings.TrimPrefix(repoURL, fmt.Sprintf("%s://", registry.OCIScheme))
+		source.client, source.cleanup, err = newRegistryClient(ref, "", "", g.config.InsecureSkipTLSverify)
+	} else {
+		// The index file is kept apart from the one of the mirrored repository
+		var cachePath string
+		cachePath, err = os.MkdirTemp("", "helm-mirror")
+		if err != nil {
+			return nil, err
+		}
+		source.cleanup = func() {
+			os.RemoveAll(cachePath)
+		}
+		source.chartRepo, err = service.loadRepository(cachePath)
+	}
+	if err != nil {
+		source.cleanup()
+		return nil, errors.Wrapf(err, "repository %s", repoURL)
+	}
+	return source, nil
+}
+
+// resolve returns the newest version of the chart matching the version range.
+func (s *dependencySource) resolve(name string, version string) (*repo.ChartVersion, error) {
+	if s.chartRepo != nil {
+		return s.chartRepo.IndexFile.Get(name, version)
+	}
+
+	if version == "" {
+		version = "*"
+	}
+	constraint, err := semver.NewConstraint(version)
+	if err != nil {
+		return nil, err
+	}
+	ref := fmt.Sprintf("%s/%s", strings.TrimPrefix(s.url, fmt.Sprintf("%s://", registry.OCIScheme)), name)
+	tags, err := s.client.Tags(ref)
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range tags {
+		v, err := semver.NewVersion(tag)
+		if err == nil && constraint.Check(v) {
+			return &repo.ChartVersion{
+				Metadata: &chart.Metadata{Name: name, Version: tag},
+				URLs:     []string{fmt.Sprintf("%s:%s", ref, tag)},
+			}, nil
+		}
+	}
+	return nil, errors.Errorf("no chart version found for %s-%s", name, version)
+}
+
+// mirror mirrors a chart of the dependency repository.
+func (s *dependencySource) mirror(worker *GetService, chart *repo.ChartVersion) (chartStatus, error) {
+	if s.client != nil {
+		return worker.mirrorOCIChart(s.client, chart)
+	}
+	return worker.mirrorChart(s.chartRepo, chart)
+}
+
+// addLocalCharts adds the charts in the destination folder to the index, when
+// not in it yet, with the charts that required them.
+func (g *GetService) addLocalCharts(index *repo.IndexFile, charts []*repo.ChartVersion, requiredBy map[string][]string) error {
+	for _, c := range charts {
+		if index.Has(c.Name, c.Version) {
+			continue
+		}
+		chartFileName := chartKey(c) + ".tgz"
+		chartPath := path.Join(g.config.Name, chartFileName)
+
+		loaded, err := loader.Load(chartPath)
+		if err != nil {
+			return err
+		}
+		digest, err := provenance.DigestFile(chartPath)
+		if err != nil {
+			return err
+		}
+		if parents := requiredBy[chartKey(c)]; len(parents) > 0 {
+			if loaded.Metadata.Annotations == nil {
+				loaded.Metadata.Annotations = make(map[string]string)
+			}
+			loaded.Metadata.Annotations[requiredByAnnotation] = strings.Join(parents, ",")
+		}
+		err = index.MustAdd(loaded.Metadata, chartFileName, g.newRootURL, digest)
+		if err != nil {
+			return err
+		}
+	}
+	index.SortEntries()
+	return nil
+}
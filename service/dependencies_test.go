@@ -0,0 +1,150 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+func TestGetService_Get_dependencies(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	upstream := path.Join(dir, "upstream")
+	dependencies := path.Join(dir, "dependencies")
+	os.MkdirAll(upstream, 0744)
+	os.MkdirAll(dependencies, 0744)
+	upstreamSvr := httptest.NewServer(http.FileServer(http.Dir(upstream)))
+	defer upstreamSvr.Close()
+	dependenciesSvr := httptest.NewServer(http.FileServer(http.Dir(dependencies)))
+	defer dependenciesSvr.Close()
+
+	bundled := &chart.Chart{Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "bundled", Version: "1.0.0"}}
+	packageTestChartWithDependencies(t, upstream, "umbrella", "1.0.0", []*chart.Chart{bundled},
+		&chart.Dependency{Name: "dep", Version: "^1.0.0", Repository: dependenciesSvr.URL},
+		&chart.Dependency{Name: "bundled", Version: "1.0.0", Repository: dependenciesSvr.URL},
+		&chart.Dependency{Name: "local", Version: "1.0.0", Repository: "file://../local"})
+	writeTestIndex(t, upstream, upstreamSvr.URL)
+
+	packageTestChart(t, dependencies, "dep", "1.0.0")
+	packageTestChartWithDependencies(t, dependencies, "dep", "1.1.0", nil,
+		&chart.Dependency{Name: "leaf", Version: "~0.1.0", Repository: dependenciesSvr.URL + "/"})
+	packageTestChart(t, dependencies, "dep", "2.0.0")
+	packageTestChart(t, dependencies, "leaf", "0.1.0")
+	packageTestChart(t, dependencies, "leaf", "0.2.0")
+	// Relative chart URLs
+	writeTestIndex(t, dependencies, "")
+
+	tests := []struct {
+		name         string
+		ignoreErrors bool
+		wantErr      bool
+		wantFiles    []string
+		wantMissing  []string
+	}{
+		{"1", true, false, []string{"umbrella-1.0.0.tgz", "dep-1.1.0.tgz", "leaf-0.1.0.tgz"}, []string{"dep-1.0.0.tgz", "dep-2.0.0.tgz", "leaf-0.2.0.tgz", "bundled-1.0.0.tgz"}},
+		{"2", false, true, []string{"umbrella-1.0.0.tgz"}, []string{"dep-1.1.0.tgz"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workDir := path.Join(dir, "mirror", tt.name)
+			os.MkdirAll(workDir, 0744)
+			g := &GetService{
+				config:       repo.Entry{Name: workDir, URL: upstreamSvr.URL},
+				logger:       fakeLogger,
+				ignoreErrors: tt.ignoreErrors,
+				options:      GetOptions{WithDependencies: true},
+			}
+			if err := g.Get(); (err != nil) != tt.wantErr {
+				t.Errorf("GetService.Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for _, f := range tt.wantFiles {
+				if !fileExists(path.Join(workDir, f)) {
+					t.Errorf("GetService.Get() missing chart %s", f)
+				}
+			}
+			for _, f := range tt.wantMissing {
+				if fileExists(path.Join(workDir, f)) {
+					t.Errorf("GetService.Get() unexpected chart %s", f)
+				}
+			}
+			if tt.wantErr {
+				return
+			}
+
+			index, err := repo.LoadIndexFile(path.Join(workDir, indexFileName))
+			if err != nil {
+				t.Fatalf("loading index: %s", err)
+			}
+			for _, want := range []struct{ name, version, requiredBy string }{
+				{"dep", "1.1.0", "umbrella-1.0.0"},
+				{"leaf", "0.1.0", "dep-1.1.0"},
+			} {
+				cv, err := index.Get(want.name, want.version)
+				if err != nil {
+					t.Fatalf("GetService.Get() index missing %s-%s: %s", want.name, want.version, err)
+				}
+				if got := cv.Annotations[requiredByAnnotation]; got != want.requiredBy {
+					t.Errorf("GetService.Get() %s-%s required by = %s, want %s", want.name, want.version, got, want.requiredBy)
+				}
+				if cv.URLs[0] != want.name+"-"+want.version+".tgz" {
+					t.Errorf("GetService.Get() %s-%s url = %s", want.name, want.version, cv.URLs[0])
+				}
+			}
+		})
+	}
+}
+
+func Test_dependencyRepository(t *testing.T) {
+	tests := []struct {
+		name       string
+		repository string
+		want       string
+		wantErr    bool
+	}{
+		{"1", "https://charts.example.com/stable/", "https://charts.example.com/stable", false},
+		{"2", "oci://registry.example.com/charts", "oci://registry.example.com/charts", false},
+		{"3", "", "", true},
+		{"4", "file://../local", "", true},
+		{"5", "@helm-mirror-missing", "", true},
+		{"6", "alias:helm-mirror-missing", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := dependencyRepository(tt.repository)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("dependencyRepository() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("dependencyRepository() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// packageTestChartWithDependencies saves a chart archive declaring the
+// dependencies, with the subcharts packaged within it, into dir.
+func packageTestChartWithDependencies(t *testing.T, dir string, name string, version string, subcharts []*chart.Chart, dependencies ...*chart.Dependency) string {
+	c := &chart.Chart{
+		Metadata: &chart.Metadata{
+			APIVersion:   chart.APIVersionV2,
+			Name:         name,
+			Version:      version,
+			Dependencies: dependencies,
+		},
+	}
+	c.SetDependencies(subcharts...)
+	chartPath, err := chartutil.Save(c, dir)
+	if err != nil {
+		t.Fatalf("packaging chart: %s", err)
+	}
+	return chartPath
+}
@@ -0,0 +1,345 @@
+package service
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/repo"
+	"sigs.k8s.io/yaml"
+)
+
+// bundleManifestName is the name of the manifest of a bundle, listing the
+// checksums of the other files of the archive
+const bundleManifestName = "manifest.yaml"
+
+// bundleImagesFolder is the folder of the OCI image layout of a bundle
+const bundleImagesFolder = "images"
+
+// bundleManifest describes the content of a bundle
+type bundleManifest struct {
+	Generated time.Time    `json:"generated"`
+	Files     []bundleFile `json:"files"`
+}
+
+// bundleFile is a file of a bundle, its name relative to the bundle root
+type bundleFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// bundleSource is a file packed into a bundle under the name
+type bundleSource struct {
+	name string
+	path string
+}
+
+// ExportServiceInterface defines an Export service
+type ExportServiceInterface interface {
+	Export() error
+}
+
+// ExportOptions selects the content of a bundle
+type ExportOptions struct {
+	// Include and Exclude are the name patterns selecting the charts
+	Include []string
+	Exclude []string
+	// Images adds the container images of the charts as an OCI image layout
+	Images bool
+	// InsecureRegistries skips the TLS verification of the image registries
+	InsecureRegistries bool
+}
+
+// ExportService structure definition
+type ExportService struct {
+	folder       string
+	archive      string
+	options      ExportOptions
+	verbose      bool
+	ignoreErrors bool
+	logger       *log.Logger
+}
+
+// NewExportService return a new instance of ExportService
+func NewExportService(folder string, archive string, options ExportOptions, verbose bool, ignoreErrors bool, logger *log.Logger) ExportServiceInterface {
+	return &ExportService{
+		folder:       folder,
+		archive:      archive,
+		options:      options,
+		verbose:      verbose,
+		ignoreErrors: ignoreErrors,
+		logger:       logger,
+	}
+}
+
+// Export packs the charts of the folder selected by the name patterns, with
+// their provenance files, into a gzipped tar archive. The archive holds an
+// index file of the charts with URLs relative to the bundle root, rewritten
+// on import, and a manifest with the checksums of every file. With Images,
+// the container images of the charts are copied into an OCI image layout
+// under the images folder of the archive.
+func (e *ExportService) Export() error {
+	include, err := newNamePatterns(e.options.Include)
+	if err != nil {
+		e.logger.Printf("error: %s", err)
+		return err
+	}
+	exclude, err := newNamePatterns(e.options.Exclude)
+	if err != nil {
+		e.logger.Printf("error: %s", err)
+		return err
+	}
+	index, err := loadExistingIndex(e.folder)
+	if err != nil {
+		e.logger.Printf("error: %s", err)
+		return err
+	}
+	if index == nil {
+		e.logger.Printf("error: no index file in folder %s", e.folder)
+		return errors.Errorf("no index file in folder %s", e.folder)
+	}
+
+	bundle := repo.NewIndexFile()
+	var files []bundleSource
+	var charts []string
+	for name, versions := range index.Entries {
+		if (len(include) > 0 && !matchesAny(include, name)) || matchesAny(exclude, name) {
+			continue
+		}
+		for _, v := range versions {
+			chartFile, err := bundleChartPath(v)
+			if err == nil && !fileExists(path.Join(e.folder, chartFile)) {
+				err = errors.Errorf("chart file %s not found", chartFile)
+			}
+			if err != nil {
+				if e.ignoreErrors {
+					e.logger.Printf("WARNING: exporting chart %s(%s) - %s", name, v.Version, err)
+					continue
+				}
+				e.logger.Printf("error: exporting chart %s(%s): %s", name, v.Version, err)
+				return err
+			}
+
+			exported := *v
+			exported.URLs = []string{chartFile}
+			bundle.Entries[name] = append(bundle.Entries[name], &exported)
+			charts = append(charts, path.Join(e.folder, chartFile))
+			files = append(files, bundleSource{chartFile, path.Join(e.folder, chartFile)})
+			if fileExists(path.Join(e.folder, chartFile+provenanceExtension)) {
+				files = append(files, bundleSource{chartFile + provenanceExtension, path.Join(e.folder, chartFile+provenanceExtension)})
+			}
+		}
+	}
+	bundle.SortEntries()
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+
+	if e.options.Images {
+		if !ImageCopySupported {
+			e.logger.Printf("error: %s", errImageCopyUnsupported)
+			return errImageCopyUnsupported
+		}
+		staging, err := os.MkdirTemp("", "helm-mirror")
+		if err != nil {
+			e.logger.Printf("error: cannot create staging folder: %s", err)
+			return err
+		}
+		defer os.RemoveAll(staging)
+		layoutFiles, err := e.exportImages(charts, path.Join(staging, bundleImagesFolder))
+		if err != nil {
+			return err
+		}
+		files = append(files, layoutFiles...)
+	}
+
+	err = e.writeArchive(files, bundle)
+	if err != nil {
+		e.logger.Printf("error: cannot write archive %s: %s", e.archive, err)
+		return err
+	}
+	e.logger.Printf("exported %d charts to %s", countCharts(bundle), e.archive)
+	return nil
+}
+
+// exportImages copies the container images of the charts into an OCI image
+// layout in the layout folder, and returns its files.
+func (e *ExportService) exportImages(charts []string, layoutFolder string) ([]bundleSource, error) {
+	images := &ImagesService{verbose: e.verbose, ignoreErrors: e.ignoreErrors, logger: e.logger}
+	for _, chartPath := range charts {
+		err := images.processTarget(chartPath)
+		if err != nil {
+			if e.ignoreErrors {
+				e.logger.Printf("WARNING: inspecting images of chart %s - %s", path.Base(chartPath), err)
+				continue
+			}
+			e.logger.Printf("error: inspecting images of chart %s: %s", path.Base(chartPath), err)
+			return nil, err
+		}
+	}
+
+	copier := newImageLayoutCopier(layoutFolder, e.options.InsecureRegistries, e.verbose, e.ignoreErrors, e.logger)
+	err := copier.copyImages(images.imageList())
+	if err != nil {
+		return nil, err
+	}
+	if copier.copied == 0 {
+		return nil, nil
+	}
+
+	var files []bundleSource
+	err = filepath.Walk(layoutFolder, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		name, err := filepath.Rel(path.Dir(layoutFolder), filePath)
+		if err != nil {
+			return err
+		}
+		files = append(files, bundleSource{filepath.ToSlash(name), filePath})
+		return nil
+	})
+	if err != nil {
+		e.logger.Printf("error: cannot read image layout: %s", err)
+		return nil, err
+	}
+	return files, nil
+}
+
+// bundleChartPath returns the path of the archive of a chart relative to the
+// folder, the file name for a chart listed with an absolute URL.
+func bundleChartPath(chart *repo.ChartVersion) (string, error) {
+	if len(chart.URLs) == 0 {
+		return "", errors.New("no URL in the index file")
+	}
+	u, err := url.Parse(chart.URLs[0])
+	if err != nil {
+		return "", err
+	}
+	if u.IsAbs() || strings.HasPrefix(u.Path, "/") {
+		return path.Base(u.Path), nil
+	}
+	name := path.Clean(u.Path)
+	if !validBundlePath(name) {
+		return "", errors.Errorf("chart URL %s is outside of the folder", chart.URLs[0])
+	}
+	return name, nil
+}
+
+// validBundlePath returns true if the name is a clean path relative to, and
+// inside of, the bundle root.
+func validBundlePath(name string) bool {
+	return name != "" && name != "." && name == path.Clean(name) && !path.IsAbs(name) &&
+		name != ".." && !strings.HasPrefix(name, "../")
+}
+
+// writeArchive writes the files, the index file and the manifest into the
+// archive, through a temporary file renamed once complete.
+func (e *ExportService) writeArchive(files []bundleSource, index *repo.IndexFile) error {
+	f, err := os.CreateTemp(path.Dir(e.archive), "."+path.Base(e.archive)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	manifest := &bundleManifest{Generated: time.Now().UTC()}
+	for _, source := range files {
+		err = addBundleFile(tw, manifest, source)
+		if err != nil {
+			return err
+		}
+		if e.verbose {
+			e.logger.Printf("exported %s", source.name)
+		}
+	}
+	content, err := yaml.Marshal(index)
+	if err != nil {
+		return err
+	}
+	err = writeTarFile(tw, manifest, indexFileName, bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return err
+	}
+	content, err = yaml.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	err = writeTarFile(tw, nil, bundleManifestName, bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return err
+	}
+
+	err = tw.Close()
+	if err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = f.Close()
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), e.archive)
+}
+
+// addBundleFile writes a file into the archive and lists it in the manifest.
+func addBundleFile(tw *tar.Writer, manifest *bundleManifest, source bundleSource) error {
+	f, err := os.Open(source.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return writeTarFile(tw, manifest, source.name, f, info.Size())
+}
+
+// writeTarFile writes a regular file into the archive, and lists it with its
+// checksum in the manifest when there is one.
+func writeTarFile(tw *tar.Writer, manifest *bundleManifest, name string, content io.Reader, size int64) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  time.Now(),
+		Typeflag: tar.TypeReg,
+	})
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tw, hash), content)
+	if err != nil {
+		return err
+	}
+	if manifest != nil {
+		manifest.Files = append(manifest.Files, bundleFile{
+			Name:   name,
+			Size:   size,
+			SHA256: hex.EncodeToString(hash.Sum(nil)),
+		})
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package service
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/repo"
+	"sigs.k8s.io/yaml"
+
+	"github.com/kplachkov/helm-mirror/fixtures"
+)
+
+func TestNewExportService(t *testing.T) {
+	options := ExportOptions{Include: []string{"a*"}, Images: true}
+	want := &ExportService{folder: "/folder", archive: "/charts.tgz", options: options, logger: fakeLogger}
+	if got := NewExportService("/folder", "/charts.tgz", options, false, false, fakeLogger); !reflect.DeepEqual(got, want) {
+		t.Errorf("NewExportService() = %v, want %v", got, want)
+	}
+}
+
+func TestExportService_Export(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name         string
+		include      []string
+		exclude      []string
+		missing      bool
+		ignoreErrors bool
+		wantFiles    []string
+		wantErr      bool
+	}{
+		{"1", nil, nil, false, false, []string{"a-1.0.0.tgz", "a-1.0.0.tgz.prov", "b-1.0.0.tgz", "index.yaml", "manifest.yaml"}, false},
+		{"2", []string{"a*"}, nil, false, false, []string{"a-1.0.0.tgz", "a-1.0.0.tgz.prov", "index.yaml", "manifest.yaml"}, false},
+		{"3", nil, []string{"regex:a|c"}, false, false, []string{"b-1.0.0.tgz", "index.yaml", "manifest.yaml"}, false},
+		{"4", nil, nil, true, false, nil, true},
+		{"5", nil, nil, true, true, []string{"a-1.0.0.tgz", "a-1.0.0.tgz.prov", "b-1.0.0.tgz", "index.yaml", "manifest.yaml"}, false},
+		{"6", []string{"["}, nil, false, false, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workDir := path.Join(dir, tt.name)
+			os.MkdirAll(workDir, 0744)
+			packageTestChart(t, workDir, "a", "1.0.0")
+			packageTestChart(t, workDir, "b", "1.0.0")
+			os.WriteFile(path.Join(workDir, "a-1.0.0.tgz.prov"), []byte("signature"), 0644)
+			index, err := repo.IndexDirectory(workDir, "https://charts.example.com")
+			if err != nil {
+				t.Fatalf("indexing charts: %s", err)
+			}
+			if tt.missing {
+				index.MustAdd(&chart.Metadata{APIVersion: chart.APIVersionV2, Name: "c", Version: "1.0.0"}, "c-1.0.0.tgz", "", "digest")
+			}
+			index.WriteFile(path.Join(workDir, indexFileName), 0644)
+
+			archive := path.Join(dir, tt.name+".tgz")
+			e := NewExportService(workDir, archive, ExportOptions{Include: tt.include, Exclude: tt.exclude}, false, tt.ignoreErrors, fakeLogger)
+			if err := e.Export(); (err != nil) != tt.wantErr {
+				t.Fatalf("ExportService.Export() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if fileExists(archive) {
+					t.Errorf("ExportService.Export() wrote archive %s", archive)
+				}
+				return
+			}
+
+			files := readTestBundle(t, archive)
+			var names []string
+			for name := range files {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, tt.wantFiles) {
+				t.Errorf("ExportService.Export() files = %v, want %v", names, tt.wantFiles)
+			}
+
+			exported := &repo.IndexFile{}
+			yaml.Unmarshal(files[indexFileName], exported)
+			for name, versions := range exported.Entries {
+				if want := []string{name + "-1.0.0.tgz"}; !reflect.DeepEqual(versions[0].URLs, want) {
+					t.Errorf("ExportService.Export() URLs of %s = %v, want %v", name, versions[0].URLs, want)
+				}
+			}
+			manifest := &bundleManifest{}
+			yaml.Unmarshal(files[bundleManifestName], manifest)
+			if len(manifest.Files) != len(tt.wantFiles)-1 {
+				t.Errorf("ExportService.Export() manifest lists %d files, want %d", len(manifest.Files), len(tt.wantFiles)-1)
+			}
+		})
+	}
+}
+
+func TestExportService_Export_images(t *testing.T) {
+	if !ImageCopySupported {
+		t.Skip(errImageCopyUnsupported)
+	}
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	host, err := fixtures.StartRegistry()
+	if err != nil {
+		t.Fatalf("starting registry: %s", err)
+	}
+	_, err = fixtures.PushImage(host, "app", "1.0", []byte("app layer"))
+	if err != nil {
+		t.Fatalf("pushing image: %s", err)
+	}
+	folder := path.Join(dir, "folder")
+	os.MkdirAll(folder, 0744)
+	for name, image := range map[string]string{"a": host + "/app:1.0", "b": host + "/missing:1.0"} {
+		_, err = chartutil.Save(&chart.Chart{
+			Metadata:  &chart.Metadata{APIVersion: chart.APIVersionV2, Name: name, Version: "1.0.0"},
+			Templates: []*chart.File{{Name: "templates/pod.yaml", Data: []byte("image: " + image + "\n")}},
+		}, folder)
+		if err != nil {
+			t.Fatalf("packaging chart: %s", err)
+		}
+	}
+	writeTestIndex(t, folder, "")
+
+	tests := []struct {
+		name         string
+		include      []string
+		ignoreErrors bool
+		wantImages   bool
+		wantErr      bool
+	}{
+		{"1", []string{"a"}, false, true, false},
+		{"2", nil, false, false, true},
+		{"3", nil, true, true, false},
+		{"4", []string{"b"}, true, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive := path.Join(dir, tt.name+".tgz")
+			options := ExportOptions{Include: tt.include, Images: true, InsecureRegistries: true}
+			e := NewExportService(folder, archive, options, false, tt.ignoreErrors, fakeLogger)
+			if err := e.Export(); (err != nil) != tt.wantErr {
+				t.Fatalf("ExportService.Export() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			files := readTestBundle(t, archive)
+			layoutIndex, ok := files[path.Join(bundleImagesFolder, imageLayoutIndexName)]
+			if ok != tt.wantImages {
+				t.Fatalf("ExportService.Export() image layout in archive = %v, want %v", ok, tt.wantImages)
+			}
+			if !ok {
+				return
+			}
+			var index imgspecv1.Index
+			json.Unmarshal(layoutIndex, &index)
+			if len(index.Manifests) != 1 || index.Manifests[0].Annotations[imgspecv1.AnnotationRefName] != host+"/app:1.0" {
+				t.Errorf("ExportService.Export() image layout lists %v, want %s", index.Manifests, host+"/app:1.0")
+			}
+			manifest := &bundleManifest{}
+			yaml.Unmarshal(files[bundleManifestName], manifest)
+			if len(manifest.Files) != len(files)-1 {
+				t.Errorf("ExportService.Export() manifest lists %d files, want %d", len(manifest.Files), len(files)-1)
+			}
+		})
+	}
+}
+
+func Test_bundleChartPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		urls    []string
+		want    string
+		wantErr bool
+	}{
+		{"1", []string{"a-1.0.0.tgz"}, "a-1.0.0.tgz", false},
+		{"2", []string{"https://charts.example.com/stable/a-1.0.0.tgz"}, "a-1.0.0.tgz", false},
+		{"3", []string{"one/a-1.0.0.tgz"}, "one/a-1.0.0.tgz", false},
+		{"4", []string{"../a-1.0.0.tgz"}, "", true},
+		{"5", nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := bundleChartPath(&repo.ChartVersion{URLs: tt.urls})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("bundleChartPath() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("bundleChartPath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// readTestBundle returns the content of the files of a bundle.
+func readTestBundle(t *testing.T, archive string) map[string][]byte {
+	f, err := os.Open(archive)
+	if err != nil {
+		t.Fatalf("opening archive: %s", err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("reading archive: %s", err)
+	}
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatalf("reading archive: %s", err)
+		}
+		files[header.Name], _ = io.ReadAll(tr)
+	}
+}
@@ -7,10 +7,16 @@ import (
 	"log"
 	"os"
 	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"helm.sh/helm/v3/cmd/helm/search"
+	"github.com/pkg/errors"
 	"helm.sh/helm/v3/pkg/cli"
 	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/provenance"
+	"helm.sh/helm/v3/pkg/registry"
 	"helm.sh/helm/v3/pkg/repo"
 )
 
@@ -23,6 +29,78 @@ type GetServiceInterface interface {
 	Get() error
 }
 
+// GetOptions defines the optional behaviour of a GetService
+type GetOptions struct {
+	// Incremental skips the charts already present in the destination
+	// folder whose digest matches the one in the index file
+	Incremental bool
+	// QuarantineDir keeps the downloaded charts whose digest does not match
+	// the index file when errors are ignored, they are discarded if empty
+	QuarantineDir string
+	// Provenance mirrors the provenance (.prov) files next to the charts
+	Provenance bool
+	// Keyring verifies every signed chart against the public keys it holds
+	Keyring string
+	// RequireSigned rejects the charts without a provenance file
+	RequireSigned bool
+	// Concurrency is the number of charts downloaded in parallel
+	Concurrency int
+	// Retries is the number of times a failed download is retried
+	Retries int
+	// RetryBackoff is the initial wait between retries, doubled on every retry
+	RetryBackoff time.Duration
+	// VersionConstraint is a semver constraint applied to the versions of
+	// every chart, all the matching versions are mirrored
+	VersionConstraint string
+	// IncludePrereleases matches the pre-releases against the version
+	// constraints by their release version
+	IncludePrereleases bool
+	// ExcludePrereleases never mirrors pre-releases
+	ExcludePrereleases bool
+	// KeepVersions mirrors only the newest versions of every chart, or of
+	// every version line of a chart, when greater than zero
+	KeepVersions int
+	// KeepVersionsPer is the version line KeepVersions applies to, one of
+	// KeepPerChart, KeepPerMajor or KeepPerMinor
+	KeepVersionsPer string
+	// Since mirrors only the charts created at or after this time
+	Since time.Time
+	// Until mirrors only the charts created at or before this time
+	Until time.Time
+	// WithDependencies mirrors the dependencies of the mirrored charts, that
+	// are not packaged within them, from their own repositories
+	WithDependencies bool
+	// Prune deletes the charts of the destination folder that are not
+	// mirrored anymore, with their provenance files
+	Prune bool
+	// PruneDryRun lists the charts Prune would delete without deleting them
+	PruneDryRun bool
+	// DryRun prints the plan of the mirror, in PlanFormat, without writing
+	// anything into the destination folder
+	DryRun bool
+	// PlanFormat is the format of the plan, PlanText or PlanJSON
+	PlanFormat string
+	// RegenerateIndex rebuilds the index file from the charts present in the
+	// destination folder instead of the mirrored ones
+	RegenerateIndex bool
+	// Include restricts the mirrored charts to the ones whose name matches
+	// any of the glob or regex: patterns
+	Include []string
+	// Exclude never mirrors the charts whose name matches any of the glob or
+	// regex: patterns
+	Exclude []string
+	// Charts restricts the mirrored charts to the ones selected, all the
+	// charts of the repository are mirrored if empty
+	Charts []ChartSelection
+}
+
+// ChartSelection selects a chart, and optionally the versions matching a
+// version or a semver constraint, to mirror
+type ChartSelection struct {
+	Name    string `yaml:"name"`
+	Version string `yaml:"version,omitempty"`
+}
+
 // GetService structure definition
 type GetService struct {
 	config        repo.Entry
@@ -34,10 +112,55 @@ type GetService struct {
 	chartName     string
 	chartVersion  string
 	indexFilePath string
+	out           io.Writer
+	options       GetOptions
+	summary       summary
+	signatory     *provenance.Signatory
+	// keptDependencies are the names of the dependencies whose versions in
+	// the destination folder are never pruned, their charts having failed
+	keptDependencies map[string]bool
+}
+
+// summary counts what happened to the charts selected by a GetService
+type summary struct {
+	downloaded int
+	replaced   int
+	skipped    int
+	failed     int
+}
+
+// add adds the counts of another summary to the summary.
+func (s *summary) add(o summary) {
+	s.downloaded += o.downloaded
+	s.replaced += o.replaced
+	s.skipped += o.skipped
+	s.failed += o.failed
+}
+
+func (s summary) String() string {
+	return fmt.Sprintf("%d downloaded, %d replaced, %d skipped, %d failed", s.downloaded, s.replaced, s.skipped, s.failed)
+}
+
+// chartStatus defines what happened to a chart selected by a GetService
+type chartStatus int
+
+// Enum for chartStatus
+const (
+	chartFailed chartStatus = iota
+	chartDownloaded
+	chartReplaced
+	chartSkipped
+)
+
+// chartResult holds the outcome and the log output of mirroring a chart
+type chartResult struct {
+	status chartStatus
+	err    error
+	log    bytes.Buffer
 }
 
 // NewGetService return a new instance of GetService
-func NewGetService(config repo.Entry, allVersions bool, verbose bool, ignoreErrors bool, logger *log.Logger, newRootURL string, chartName string, chartVersion string) GetServiceInterface {
+func NewGetService(config repo.Entry, allVersions bool, verbose bool, ignoreErrors bool, logger *log.Logger, newRootURL string, chartName string, chartVersion string, options GetOptions) GetServiceInterface {
 	return &GetService{
 		config:       config,
 		verbose:      verbose,
@@ -47,135 +170,440 @@ func NewGetService(config repo.Entry, allVersions bool, verbose bool, ignoreErro
 		allVersions:  allVersions,
 		chartName:    chartName,
 		chartVersion: chartVersion,
+		options:      options,
+		out:          os.Stdout,
 	}
 }
 
 // Get methods downloads the index file and the Helm charts to the working directory.
 func (g *GetService) Get() error {
-	chartRepo, err := repo.NewChartRepository(&g.config, getter.All(&cli.EnvSettings{}))
+	if registry.IsOCI(g.config.URL) {
+		return g.getOCI()
+	}
+
+	err := g.loadKeyring()
 	if err != nil {
 		return err
 	}
 
-	g.indexFilePath, err = chartRepo.DownloadIndexFile()
+	chartRepo, err := g.loadRepository("")
 	if err != nil {
 		return err
 	}
 
-	chartRepo.IndexFile, err = repo.LoadIndexFile(g.indexFilePath)
+	charts, err := g.filterCharts(sortedCharts(chartRepo.IndexFile))
 	if err != nil {
 		return err
 	}
 
-	index := search.NewIndex()
-	index.AddRepo(chartRepo.Config.Name, chartRepo.IndexFile, g.allVersions || g.chartVersion != "")
+	if g.options.DryRun {
+		defer os.Remove(g.indexFilePath)
+		httpGetter, err := newHTTPGetter(g.config, g.options.Retries, g.options.RetryBackoff, g.verbose, g.logger)
+		if err != nil {
+			return err
+		}
+		return g.plan(charts, g.httpChartSize(httpGetter))
+	}
 
-	chartNameRegex := fmt.Sprintf("^.*%s.*", g.chartName)
-	results, err := index.Search(chartNameRegex, 1, true)
+	mirrored, err := g.mirrorCharts(charts, func(worker *GetService, chart *repo.ChartVersion) (chartStatus, error) {
+		return worker.mirrorChart(chartRepo, chart)
+	})
 	if err != nil {
 		return err
 	}
 
-	for _, res := range results {
-		if g.chartName != "" && res.Chart.Name != g.chartName {
-			continue
-		}
-		if g.chartVersion != "" && res.Chart.Version != g.chartVersion {
-			continue
+	g.logger.Printf("charts: %s", g.summary)
+
+	var dependencies []*repo.ChartVersion
+	var requiredBy map[string][]string
+	if g.options.WithDependencies {
+		dependencies, requiredBy, err = g.mirrorDependencies(charts, mirrored)
+		if err != nil {
+			return err
 		}
+	}
 
-		for _, u := range res.Chart.URLs {
-			b, err := chartRepo.Client.Get(u)
-			if err != nil {
-				if g.ignoreErrors {
-					g.logger.Printf("WARNING: processing chart %s(%s) - %s", res.Name, res.Chart.Version, err)
-					continue
-				} else {
-					return err
+	// Pruned before the index file is published, which may be rebuilt
+	// from the charts of the folder.
+	err = g.pruneCharts(charts, requiredBy)
+	if err != nil {
+		return err
+	}
+	return g.prepareIndexFile(mirrored, dependencies, requiredBy)
+}
+
+// loadRepository downloads and loads the index file of the chart repository,
+// into cachePath or the Helm cache if empty.
+func (g *GetService) loadRepository(cachePath string) (*repo.ChartRepository, error) {
+	httpGetter, err := newHTTPGetter(g.config, g.options.Retries, g.options.RetryBackoff, g.verbose, g.logger)
+	if err != nil {
+		return nil, err
+	}
+
+	providers := append(getter.Providers{httpGetter.provider()}, getter.All(&cli.EnvSettings{})...)
+	chartRepo, err := repo.NewChartRepository(&g.config, providers)
+	if err != nil {
+		return nil, err
+	}
+	if cachePath != "" {
+		chartRepo.CachePath = cachePath
+	}
+
+	g.indexFilePath, err = chartRepo.DownloadIndexFile()
+	if err != nil {
+		return nil, err
+	}
+
+	chartRepo.IndexFile, err = repo.LoadIndexFile(g.indexFilePath)
+	if err != nil {
+		return nil, err
+	}
+	return chartRepo, nil
+}
+
+// mirrorCharts calls mirror for every chart through a pool of workers bounded
+// by the concurrency option, and returns the charts that are in the
+// destination folder afterwards. The log output of every chart is buffered
+// and flushed in the order of the charts, so the output does not depend on
+// the timing of the downloads. When errors are not ignored no new download
+// is started after a failure and the error of the first failing chart is
+// returned.
+func (g *GetService) mirrorCharts(charts []*repo.ChartVersion, mirror func(worker *GetService, chart *repo.ChartVersion) (chartStatus, error)) ([]*repo.ChartVersion, error) {
+	workers := g.options.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make([]chartResult, len(charts))
+	jobs := make(chan int)
+	done := make(chan int)
+	var failed int32
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				worker := *g
+				worker.logger = log.New(&results[i].log, g.logger.Prefix(), g.logger.Flags())
+				results[i].status, results[i].err = mirror(&worker, charts[i])
+				if results[i].err != nil {
+					atomic.StoreInt32(&failed, 1)
 				}
+				done <- i
 			}
+		}()
+	}
 
-			chartFileName := fmt.Sprintf("%s-%s.tgz", res.Chart.Name, res.Chart.Version)
-			chartPath := path.Join(g.config.Name, chartFileName)
-
-			err = g.writeFile(chartPath, b.Bytes())
-			if err != nil {
-				return err
+	go func() {
+		for i := range charts {
+			if atomic.LoadInt32(&failed) == 1 {
+				break
 			}
+			jobs <- i
+		}
+		close(jobs)
+		wg.Wait()
+		close(done)
+	}()
+
+	finished := make([]bool, len(charts))
+	next := 0
+	for i := range done {
+		finished[i] = true
+		for ; next < len(charts) && finished[next]; next++ {
+			g.logger.Writer().Write(results[next].log.Bytes())
 		}
 	}
 
-	err = g.prepareIndexFile()
-	return err
+	var mirrored []*repo.ChartVersion
+	for i, r := range results[:next] {
+		if r.err != nil {
+			return nil, r.err
+		}
+		switch r.status {
+		case chartDownloaded:
+			g.summary.downloaded++
+		case chartReplaced:
+			g.summary.replaced++
+		case chartSkipped:
+			g.summary.skipped++
+		default:
+			g.summary.failed++
+			continue
+		}
+		mirrored = append(mirrored, charts[i])
+	}
+	return mirrored, nil
 }
 
-func (g *GetService) writeFile(name string, content []byte) error {
-	err := os.WriteFile(name, content, 0666)
-	if g.ignoreErrors {
-		g.logger.Printf("cannot write files %s: %s", name, err)
-	} else {
-		return err
+// mirrorChart downloads, verifies and writes a single chart to the destination folder.
+func (g *GetService) mirrorChart(chartRepo *repo.ChartRepository, chart *repo.ChartVersion) (chartStatus, error) {
+	chartFileName := fmt.Sprintf("%s-%s.tgz", chart.Name, chart.Version)
+	chartPath := path.Join(g.config.Name, chartFileName)
+
+	exists, upToDate := g.checkLocalChart(chartPath, chart.Digest)
+	if upToDate {
+		if g.verbose {
+			g.logger.Printf("skipping chart %s(%s): already mirrored", chart.Name, chart.Version)
+		}
+		return chartSkipped, nil
 	}
-	return nil
-}
 
-func (g *GetService) prepareIndexFile() error {
-	indexPath := path.Join(g.config.Name, indexFileName)
+	// Downloads are written to a part file, resumed if interrupted, and only
+	// renamed into place once verified
+	partPath := path.Join(g.config.Name, partFileName(chartFileName))
+	if chart.Digest == "" {
+		// Nothing would verify the content of a part file left by an
+		// earlier run
+		os.Remove(partPath)
+		os.Remove(partPath + validatorExtension)
+	}
+	for _, u := range chart.URLs {
+		// Relative chart URLs are relative to the repository URL
+		u, err := repo.ResolveReferenceURL(g.config.URL, u)
+		if err != nil {
+			return g.chartError(chart, err)
+		}
+		content, err := g.downloadChart(chartRepo, u, partPath)
+		if err != nil {
+			if g.ignoreErrors {
+				g.logger.Printf("WARNING: processing chart %s(%s) - %s", chart.Name, chart.Version, err)
+				continue
+			} else {
+				return chartFailed, err
+			}
+		}
 
-	if g.newRootURL != "" {
-		indexContent, err := os.ReadFile(g.indexFilePath)
+		err = g.verifyDigest(chart, content)
 		if err != nil {
-			return err
+			os.Remove(partPath)
+			if g.ignoreErrors {
+				g.logger.Printf("WARNING: processing chart %s(%s) - %s", chart.Name, chart.Version, err)
+				g.quarantine(chartFileName, content)
+				continue
+			} else {
+				return chartFailed, err
+			}
 		}
 
-		content := bytes.Replace(indexContent, []byte(g.config.URL), []byte(g.newRootURL), -1)
+		prov, err := g.getProvenance(chartRepo, chart, u, chartFileName, content)
+		if err != nil {
+			os.Remove(partPath)
+			if g.ignoreErrors {
+				g.logger.Printf("WARNING: processing chart %s(%s) - %s", chart.Name, chart.Version, err)
+				g.quarantine(chartFileName, content)
+				continue
+			} else {
+				return chartFailed, err
+			}
+		}
 
-		err = g.writeFile(g.indexFilePath, content)
+		// The provenance file is written first, so that a chart in place
+		// always has its own
+		if prov != nil {
+			err = g.writeFile(chartPath+provenanceExtension, prov)
+			if err != nil {
+				return g.chartError(chart, err)
+			}
+		}
+		err = os.Rename(partPath, chartPath)
 		if err != nil {
-			return err
+			return g.chartError(chart, err)
+		}
+
+		if exists {
+			return chartReplaced, nil
+		}
+		return chartDownloaded, nil
+	}
+	return chartFailed, nil
+}
+
+// downloadChart downloads the chart at u into partPath and returns its
+// content. The http(s) downloads resume from the content already in partPath.
+func (g *GetService) downloadChart(chartRepo *repo.ChartRepository, u string, partPath string) ([]byte, error) {
+	if httpGetter, ok := chartRepo.Client.(*httpGetter); ok {
+		err := httpGetter.Download(u, partPath)
+		if err != nil {
+			return nil, err
 		}
+		return os.ReadFile(partPath)
 	}
 
-	return moveFile(g.indexFilePath, indexPath)
+	b, err := chartRepo.Client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), os.WriteFile(partPath, b.Bytes(), 0666)
 }
 
-func moveFile(src string, dst string) error {
-	if src == dst {
+// verifyDigest checks the downloaded chart archive against the digest in the index file.
+func (g *GetService) verifyDigest(chart *repo.ChartVersion, content []byte) error {
+	if chart.Digest == "" {
+		if g.verbose {
+			g.logger.Printf("chart %s(%s) has no digest in the index file, skipping verification", chart.Name, chart.Version)
+		}
 		return nil
 	}
+	digest, err := provenance.Digest(bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	if digest != chart.Digest {
+		return errors.Errorf("digest mismatch for chart %s(%s): expected %s, got %s", chart.Name, chart.Version, chart.Digest, digest)
+	}
+	return nil
+}
+
+// quarantine keeps a chart archive that failed verification out of the destination folder.
+func (g *GetService) quarantine(name string, content []byte) {
+	if g.options.QuarantineDir == "" {
+		return
+	}
+	err := os.MkdirAll(g.options.QuarantineDir, 0744)
+	if err != nil {
+		g.logger.Printf("cannot create quarantine folder %s: %s", g.options.QuarantineDir, err)
+		return
+	}
+	quarantinePath := path.Join(g.options.QuarantineDir, name)
+	err = os.WriteFile(quarantinePath, content, 0666)
+	if err != nil {
+		g.logger.Printf("cannot write files %s: %s", quarantinePath, err)
+		return
+	}
+	g.logger.Printf("chart quarantined in %s", quarantinePath)
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// localChartStatus reports whether the chart archive exists in the destination
+// folder and whether its SHA-256 matches the digest from the index file.
+func localChartStatus(chartPath string, digest string) (exists bool, matches bool) {
+	if !fileExists(chartPath) {
+		return false, false
+	}
+	if digest == "" {
+		return true, false
+	}
+	localDigest, err := provenance.DigestFile(chartPath)
+	if err != nil {
+		return true, false
+	}
+	return true, localDigest == digest
+}
 
-	in, err := os.Open(src)
+// checkLocalChart reports whether the chart file is in the destination folder
+// and, on an incremental mirror, does not have to be downloaded again.
+func (g *GetService) checkLocalChart(chartPath string, digest string) (exists bool, upToDate bool) {
+	exists, matches := localChartStatus(chartPath, digest)
+	upToDate = g.options.Incremental && matches && (!g.options.RequireSigned || fileExists(chartPath+provenanceExtension))
+	return exists, upToDate
+}
+
+// writeFile writes the content to name through a temporary file of the same
+// folder renamed to name once complete, so that name is never left partly
+// written.
+func (g *GetService) writeFile(name string, content []byte) error {
+	err := writeFileAtomic(name, content)
+	if err != nil {
+		g.logger.Printf("cannot write files %s: %s", name, err)
+	}
+	return err
+}
+
+// writeFileAtomic writes the content to a temporary file next to name, synced
+// to disk and then renamed to name.
+func writeFileAtomic(name string, content []byte) error {
+	f, err := os.CreateTemp(path.Dir(name), "."+path.Base(name)+".tmp-*")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(f.Name())
 
-	out, err := os.Create(dst)
+	_, err = f.Write(content)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
 	if err != nil {
-		in.Close()
 		return err
 	}
-	defer out.Close()
+	return os.Rename(f.Name(), name)
+}
+
+// prepareIndexFile writes the index file of the destination folder out of the
+// downloaded index file. Only the mirrored charts and dependencies are kept,
+// their URLs pointing at the mirrored files under the new root URL, or
+// relative to the index file.
+func (g *GetService) prepareIndexFile(charts []*repo.ChartVersion, dependencies []*repo.ChartVersion, requiredBy map[string][]string) error {
+	indexPath := path.Join(g.config.Name, indexFileName)
 
-	_, err = io.Copy(out, in)
-	in.Close()
+	index, err := repo.LoadIndexFile(g.indexFilePath)
 	if err != nil {
 		return err
 	}
 
-	defer func() {
-		os.Remove(src)
-	}()
+	mirrored := make(map[string]bool)
+	for _, c := range charts {
+		mirrored[chartKey(c)] = true
+	}
+	for name, versions := range index.Entries {
+		var kept repo.ChartVersions
+		for _, v := range versions {
+			if !mirrored[chartKey(v)] {
+				continue
+			}
+			v.URLs = []string{g.chartURL(chartKey(v) + ".tgz")}
+			kept = append(kept, v)
+		}
+		if len(kept) == 0 {
+			delete(index.Entries, name)
+			continue
+		}
+		index.Entries[name] = kept
+	}
 
-	err = out.Sync()
+	err = g.addLocalCharts(index, dependencies, requiredBy)
 	if err != nil {
 		return err
 	}
 
-	si, err := os.Stat(src)
+	err = g.publishIndex(index)
 	if err != nil {
 		return err
 	}
+	if g.indexFilePath != indexPath {
+		os.Remove(g.indexFilePath)
+	}
+	return nil
+}
 
-	err = os.Chmod(dst, si.Mode())
-	return err
+// chartURL returns the URL of a mirrored chart file in the index file.
+func (g *GetService) chartURL(chartFileName string) string {
+	return joinRootURL(g.newRootURL, chartFileName)
+}
+
+// joinRootURL returns the URL of a chart file under the root URL, the file
+// name alone without a root URL.
+func joinRootURL(rootURL string, chartFileName string) string {
+	if rootURL == "" {
+		return chartFileName
+	}
+	return strings.TrimSuffix(rootURL, "/") + "/" + chartFileName
+}
+
+// partFileName returns the name of the hidden part file the chart file is
+// downloaded to.
+func partFileName(chartFileName string) string {
+	return "." + chartFileName + partExtension
 }
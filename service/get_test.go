@@ -1,13 +1,21 @@
 package service
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
+	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
 	"testing"
 
+	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/repo"
 
 	"github.com/kplachkov/helm-mirror/fixtures"
@@ -28,7 +36,7 @@ func TestNewGetService(t *testing.T) {
 	}
 	defer os.RemoveAll(dir)
 	config := repo.Entry{Name: dir, URL: "http://helmrepo"}
-	gService := &GetService{config: config, logger: fakeLogger, newRootURL: "https://newchartserver.com", allVersions: false}
+	gService := &GetService{config: config, logger: fakeLogger, newRootURL: "https://newchartserver.com", allVersions: false, out: os.Stdout}
 	type args struct {
 		helmRepo     string
 		workspace    string
@@ -49,7 +57,7 @@ func TestNewGetService(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := NewGetService(config, tt.args.verbose, tt.args.allVersions, tt.args.ignoreErrors, tt.args.logger, tt.args.newRootURL, tt.args.chartName, tt.args.chartVersion); !reflect.DeepEqual(got, tt.want) {
+			if got := NewGetService(config, tt.args.verbose, tt.args.allVersions, tt.args.ignoreErrors, tt.args.logger, tt.args.newRootURL, tt.args.chartName, tt.args.chartVersion, GetOptions{}); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("NewGetService() = %v, want %v", got, tt.want)
 			}
 		})
@@ -63,7 +71,7 @@ func TestGetService_Get(t *testing.T) {
 	}
 	defer os.RemoveAll(dir)
 	svr := fixtures.StartHTTPServer()
-	defer svr.Shutdown(nil)
+	defer svr.Shutdown(context.Background())
 	fixtures.WaitForServer("http://127.0.0.1:1793/alive")
 	type fields struct {
 		repoURL      string
@@ -88,7 +96,7 @@ func TestGetService_Get(t *testing.T) {
 		{"6", fields{"http://127.0.0.1:1793", path.Join(dir, "get"), true, true, false, "", ""}, false, 3},
 		{"7", fields{"http://127.0.0.1:1793", path.Join(dir, "get"), true, true, false, "chart2", ""}, false, 1},
 		{"8", fields{"http://127.0.0.1:1793", path.Join(dir, "get"), true, true, false, "chart", ""}, false, 0},
-		{"9", fields{"http://127.0.0.1:1793", path.Join(dir, "get"), true, true, false, `^(?:(?:aa)|.$`, ""}, true, 0},
+		{"9", fields{"http://127.0.0.1:1793", path.Join(dir, "get"), true, true, false, `^(?:(?:aa)|.$`, ""}, false, 0},
 		{"10", fields{"http://127.0.0.1:1793", path.Join(dir, "get"), true, true, false, "chart2", "7.0.0"}, false, 0},
 		{"11", fields{"http://127.0.0.1:1793", path.Join(dir, "get"), true, true, false, "chart2", "0.0.0-rc1"}, false, 1},
 		{"12", fields{"http://127.0.0.1:1793", path.Join(dir, "get"), true, true, true, "chart2", ""}, false, 2},
@@ -128,6 +136,291 @@ func TestGetService_Get(t *testing.T) {
 	}
 }
 
+func TestGetService_Get_incremental(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	svr := fixtures.StartHTTPServer()
+	defer svr.Shutdown(context.Background())
+	fixtures.WaitForServer("http://127.0.0.1:1793/alive")
+	err = os.WriteFile(path.Join(dir, "chart2-0.0.0-rc1.tgz"), []byte("stale"), 0666)
+	if err != nil {
+		t.Errorf("os.WriteFile() error = %v", err)
+	}
+	tests := []struct {
+		name string
+		want summary
+	}{
+		{"1", summary{downloaded: 1, replaced: 1}},
+		{"2", summary{skipped: 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &GetService{
+				config:       repo.Entry{Name: dir, URL: "http://127.0.0.1:1793"},
+				logger:       fakeLogger,
+				ignoreErrors: true,
+				allVersions:  true,
+				chartName:    "chart2",
+				options:      GetOptions{Incremental: true},
+			}
+			if err := g.Get(); err != nil {
+				t.Errorf("GetService.Get() error = %v", err)
+			}
+			if g.summary != tt.want {
+				t.Errorf("GetService.Get() summary = %v, want %v", g.summary, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetService_Get_concurrency(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	svr := fixtures.StartHTTPServer()
+	defer svr.Shutdown(context.Background())
+	fixtures.WaitForServer("http://127.0.0.1:1793/alive")
+	type want struct {
+		summary summary
+		log     string
+	}
+	var sequential want
+	tests := []struct {
+		name         string
+		concurrency  int
+		ignoreErrors bool
+		wantErr      bool
+	}{
+		{"1", 1, true, false},
+		{"2", 2, true, false},
+		{"3", 8, true, false},
+		{"4", 8, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			workDir := path.Join(dir, "get")
+			os.RemoveAll(workDir)
+			os.MkdirAll(workDir, 0744)
+			g := &GetService{
+				config:       repo.Entry{Name: workDir, URL: "http://127.0.0.1:1793"},
+				logger:       log.New(&buf, "test:", 0),
+				ignoreErrors: tt.ignoreErrors,
+				verbose:      true,
+				allVersions:  true,
+				options:      GetOptions{Concurrency: tt.concurrency},
+			}
+			if err := g.Get(); (err != nil) != tt.wantErr {
+				t.Errorf("GetService.Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got := want{g.summary, buf.String()}
+			if tt.concurrency == 1 {
+				sequential = got
+			} else if got != sequential {
+				t.Errorf("GetService.Get() = %v, want %v", got, sequential)
+			}
+		})
+	}
+}
+
+func TestGetService_Get_digest(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/index.yaml" {
+			fmt.Fprintf(w, tamperedIndexYaml, "http://"+r.Host)
+			return
+		}
+		w.Write([]byte("tampered chart"))
+	}))
+	defer svr.Close()
+	tests := []struct {
+		name           string
+		ignoreErrors   bool
+		wantErr        bool
+		wantQuarantine bool
+	}{
+		{"1", false, true, false},
+		{"2", true, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workDir := path.Join(dir, tt.name)
+			quarantineDir := path.Join(dir, tt.name+"-quarantine")
+			os.MkdirAll(workDir, 0744)
+			g := &GetService{
+				config:       repo.Entry{Name: workDir, URL: svr.URL},
+				logger:       fakeLogger,
+				ignoreErrors: tt.ignoreErrors,
+				options:      GetOptions{QuarantineDir: quarantineDir},
+			}
+			if err := g.Get(); (err != nil) != tt.wantErr {
+				t.Errorf("GetService.Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if _, err := os.Stat(path.Join(workDir, "tampered-1.0.0.tgz")); err == nil {
+				t.Errorf("GetService.Get() wrote a chart failing digest verification")
+			}
+			if _, err := os.Stat(path.Join(quarantineDir, "tampered-1.0.0.tgz")); (err == nil) != tt.wantQuarantine {
+				t.Errorf("GetService.Get() quarantined = %v, want %v", err == nil, tt.wantQuarantine)
+			}
+			if published := fileExists(path.Join(workDir, indexFileName)); published == tt.wantErr {
+				t.Errorf("GetService.Get() index file published = %v, want %v", published, !tt.wantErr)
+			}
+			if fileExists(path.Join(workDir, ".tampered-1.0.0.tgz.part")) {
+				t.Errorf("GetService.Get() kept the part file of a chart failing digest verification")
+			}
+		})
+	}
+}
+
+func TestGetService_Get_resume(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	upstream := path.Join(dir, "upstream")
+	os.MkdirAll(upstream, 0744)
+	var ranges []string
+	fileServer := http.FileServer(http.Dir(upstream))
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/chart-1.0.0.tgz" {
+			ranges = append(ranges, r.Header.Get("Range"))
+		}
+		fileServer.ServeHTTP(w, r)
+	}))
+	defer svr.Close()
+	chartPath := packageTestChart(t, upstream, "chart", "1.0.0")
+	writeTestIndex(t, upstream, svr.URL)
+	content, err := os.ReadFile(chartPath)
+	if err != nil {
+		t.Fatalf("reading chart: %s", err)
+	}
+	half := len(content) / 2
+
+	tests := []struct {
+		name       string
+		part       []byte
+		wantErr    bool
+		wantRanges []string
+	}{
+		{"1", nil, false, []string{""}},
+		{"2", content[:half], false, []string{fmt.Sprintf("bytes=%d-", half)}},
+		{"3", append([]byte("corrupted"), content...), false, []string{fmt.Sprintf("bytes=%d-", len(content)+9), ""}},
+		{"4", append([]byte("corrupted"), content[9:]...), true, []string{fmt.Sprintf("bytes=%d-", len(content))}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workDir := path.Join(dir, tt.name)
+			os.MkdirAll(workDir, 0744)
+			partPath := path.Join(workDir, ".chart-1.0.0.tgz.part")
+			if tt.part != nil {
+				err := os.WriteFile(partPath, tt.part, 0644)
+				if err != nil {
+					t.Fatalf("writing part file: %s", err)
+				}
+			}
+			ranges = nil
+			g := &GetService{
+				config: repo.Entry{Name: workDir, URL: svr.URL},
+				logger: fakeLogger,
+			}
+			if err := g.Get(); (err != nil) != tt.wantErr {
+				t.Errorf("GetService.Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(ranges, tt.wantRanges) {
+				t.Errorf("GetService.Get() ranges = %q, want %q", ranges, tt.wantRanges)
+			}
+			if fileExists(partPath) {
+				t.Errorf("GetService.Get() kept the part file")
+			}
+			got, err := os.ReadFile(path.Join(workDir, "chart-1.0.0.tgz"))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("GetService.Get() wrote a chart failing digest verification")
+				}
+				return
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("GetService.Get() chart content differs from upstream")
+			}
+		})
+	}
+}
+
+func TestGetService_Get_resumeWithoutDigest(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	upstream := path.Join(dir, "upstream")
+	os.MkdirAll(upstream, 0744)
+	var ranges []string
+	fileServer := http.FileServer(http.Dir(upstream))
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/chart-1.0.0.tgz" {
+			ranges = append(ranges, r.Header.Get("Range"))
+		}
+		fileServer.ServeHTTP(w, r)
+	}))
+	defer svr.Close()
+	chartPath := packageTestChart(t, upstream, "chart", "1.0.0")
+	index, err := repo.IndexDirectory(upstream, svr.URL)
+	if err != nil {
+		t.Fatalf("indexing charts: %s", err)
+	}
+	index.Entries["chart"][0].Digest = ""
+	index.WriteFile(path.Join(upstream, indexFileName), 0644)
+	content, err := os.ReadFile(chartPath)
+	if err != nil {
+		t.Fatalf("reading chart: %s", err)
+	}
+
+	// A part file of the size of the chart would be taken as complete
+	workDir := path.Join(dir, "mirror")
+	os.MkdirAll(workDir, 0744)
+	os.WriteFile(path.Join(workDir, ".chart-1.0.0.tgz.part"), bytes.Repeat([]byte("x"), len(content)), 0644)
+	g := &GetService{
+		config: repo.Entry{Name: workDir, URL: svr.URL},
+		logger: fakeLogger,
+	}
+	if err := g.Get(); err != nil {
+		t.Fatalf("GetService.Get() error = %v", err)
+	}
+	if !reflect.DeepEqual(ranges, []string{""}) {
+		t.Errorf("GetService.Get() ranges = %q, want a full download", ranges)
+	}
+	got, _ := os.ReadFile(path.Join(workDir, "chart-1.0.0.tgz"))
+	if !bytes.Equal(got, content) {
+		t.Errorf("GetService.Get() chart content differs from upstream")
+	}
+}
+
+var tamperedIndexYaml = `apiVersion: v1
+entries:
+  tampered:
+  - apiVersion: v1
+    created: 2018-09-20T00:00:00.000000000Z
+    description: A Helm chart whose archive does not match its digest
+    digest: 0000000000000000000000000000000000000000000000000000000000000000
+    name: tampered
+    urls:
+    - %s/tampered-1.0.0.tgz
+    version: 1.0.0
+`
+
 func Test_writeFile(t *testing.T) {
 	type args struct {
 		name         string
@@ -142,7 +435,8 @@ func Test_writeFile(t *testing.T) {
 	}{
 		{"1", args{"tmp.txt", []byte("test"), fakeLogger, false}, false},
 		{"2", args{"", []byte("test"), fakeLogger, false}, true},
-		{"2", args{"", []byte("test"), fakeLogger, true}, false},
+		{"3", args{"", []byte("test"), fakeLogger, true}, true},
+		{"4", args{"missing/tmp.txt", []byte("test"), fakeLogger, false}, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -154,6 +448,16 @@ func Test_writeFile(t *testing.T) {
 			if err := svc.writeFile(tt.args.name, tt.args.content); (err != nil) != tt.wantErr {
 				t.Errorf("writeFile() error = %v, wantErr %v", err, tt.wantErr)
 			}
+			if tt.wantErr {
+				return
+			}
+			content, err := os.ReadFile(tt.args.name)
+			if err != nil || !bytes.Equal(content, tt.args.content) {
+				t.Errorf("writeFile() content = %s, want %s", content, tt.args.content)
+			}
+			if temps, _ := filepath.Glob("." + tt.args.name + ".tmp-*"); len(temps) > 0 {
+				t.Errorf("writeFile() left temporary files %v", temps)
+			}
 		})
 	}
 	os.RemoveAll("tmp.txt")
@@ -169,17 +473,25 @@ func Test_prepareIndexFile(t *testing.T) {
 		folder       string
 		URL          string
 		newRootURL   string
+		mirrored     []*repo.ChartVersion
 		log          *log.Logger
 		ignoreErrors bool
 	}
+	newChart := func(name string, version string) *repo.ChartVersion {
+		return &repo.ChartVersion{Metadata: &chart.Metadata{Name: name, Version: version}}
+	}
+	all := []*repo.ChartVersion{newChart("chart1", "2.11.0"), newChart("chart2", "1.0.1"), newChart("chart2", "0.0.0-rc1"), newChart("chart3", "0.0.1-rc1")}
 	tests := []struct {
-		name    string
-		args    args
-		wantErr bool
+		name     string
+		args     args
+		wantURLs []string
+		wantErr  bool
 	}{
-		{"1", args{path.Join(dir, "processfolder"), "http://127.0.0.1:1793", "http://newchart.server.com", fakeLogger, false}, false},
-		{"2", args{path.Join(dir, "processerrorfolder"), "http://127.0.0.1:1793", "http://newchart.server.com", fakeLogger, false}, true},
-		{"3", args{path.Join(dir, "processfolder"), "http://127.0.0.1:1793", "", fakeLogger, false}, false},
+		{"1", args{path.Join(dir, "processfolder"), "http://127.0.0.1:1793", "http://newchart.server.com", all, fakeLogger, false}, []string{"http://newchart.server.com/chart1-2.11.0.tgz", "http://newchart.server.com/chart2-0.0.0-rc1.tgz", "http://newchart.server.com/chart2-1.0.1.tgz", "http://newchart.server.com/chart3-0.0.1-rc1.tgz", "http://newchart.server.com/chart3-0.0.1-rc1.tgz"}, false},
+		{"2", args{path.Join(dir, "processerrorfolder"), "http://127.0.0.1:1793", "http://newchart.server.com", all, fakeLogger, false}, nil, true},
+		{"3", args{path.Join(dir, "processfolder"), "http://127.0.0.1:1793", "", all, fakeLogger, false}, []string{"chart1-2.11.0.tgz", "chart2-0.0.0-rc1.tgz", "chart2-1.0.1.tgz", "chart3-0.0.1-rc1.tgz", "chart3-0.0.1-rc1.tgz"}, false},
+		{"4", args{path.Join(dir, "processfolder"), "https://other.server.com/charts", "http://newchart.server.com/charts/", []*repo.ChartVersion{newChart("chart2", "1.0.1")}, fakeLogger, false}, []string{"http://newchart.server.com/charts/chart2-1.0.1.tgz"}, false},
+		{"5", args{path.Join(dir, "processfolder"), "http://127.0.0.1:1793", "", nil, fakeLogger, false}, nil, false},
 	}
 	for _, tt := range tests {
 		svc := GetService{
@@ -199,22 +511,27 @@ func Test_prepareIndexFile(t *testing.T) {
 		}
 
 		t.Run(tt.name, func(t *testing.T) {
-			if err := svc.prepareIndexFile(); (err != nil) != tt.wantErr {
+			if err := svc.prepareIndexFile(tt.args.mirrored, nil, nil); (err != nil) != tt.wantErr {
 				t.Errorf("prepareIndexFile() error = %v, wantErr %v", err, tt.wantErr)
 			}
+			if tt.wantErr {
+				return
+			}
 
-			if tt.name == "1" {
-				contentBytes, err := os.ReadFile(path.Join(tt.args.folder, indexFileName))
-				if err != nil {
-					t.Log("Error reading index.yaml")
-				}
-
-				content := string(contentBytes)
-				count := strings.Count(content, tt.args.newRootURL)
-				if count != fixtures.Expectedcharts {
-					t.Errorf("prepareIndexFile() replacedCount = %v, want replacedCount %v", count, fixtures.Expectedcharts)
+			index, err := repo.LoadIndexFile(path.Join(tt.args.folder, indexFileName))
+			if err != nil {
+				t.Fatalf("loading index: %s", err)
+			}
+			var urls []string
+			for _, versions := range index.Entries {
+				for _, v := range versions {
+					urls = append(urls, v.URLs...)
 				}
 			}
+			sort.Strings(urls)
+			if !reflect.DeepEqual(urls, tt.wantURLs) {
+				t.Errorf("prepareIndexFile() urls = %v, want %v", urls, tt.wantURLs)
+			}
 		})
 	}
 }
@@ -0,0 +1,359 @@
+package service
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+const (
+	userAgent       = "helm-mirror"
+	maxRetryBackoff = 2 * time.Minute
+	// partExtension is appended to the hidden file a download is written to
+	partExtension = ".part"
+	// validatorExtension is appended to a part file to keep the ETag, or
+	// the Last-Modified date, of the content being downloaded
+	validatorExtension = ".validator"
+)
+
+// httpGetter is a getter.Getter for http(s) chart repositories. The failed
+// requests are retried with an exponential backoff and jitter, honouring the
+// Retry-After header sent by the server.
+type httpGetter struct {
+	config  repo.Entry
+	client  *http.Client
+	retries int
+	backoff time.Duration
+	verbose bool
+	logger  *log.Logger
+}
+
+// statusError is returned when the server answers with an unexpected status.
+type statusError struct {
+	url        string
+	status     string
+	statusCode int
+	retryAfter string
+}
+
+func (e *statusError) Error() string {
+	return "failed to fetch " + e.url + " : " + e.status
+}
+
+// newHTTPGetter returns a httpGetter using the TLS and credential settings of the chart repository.
+func newHTTPGetter(config repo.Entry, retries int, backoff time.Duration, verbose bool, logger *log.Logger) (*httpGetter, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.InsecureSkipTLSverify}
+	if config.CertFile != "" && config.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot load client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if config.CAFile != "" {
+		ca, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot read CA bundle")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.Errorf("no certificates found in CA bundle %s", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &httpGetter{
+		config:  config,
+		client:  &http.Client{Transport: transport},
+		retries: retries,
+		backoff: backoff,
+		verbose: verbose,
+		logger:  logger,
+	}, nil
+}
+
+// provider registers the getter for the http(s) schemes ahead of the Helm ones.
+func (h *httpGetter) provider() getter.Provider {
+	return getter.Provider{
+		Schemes: []string{"http", "https"},
+		New: func(options ...getter.Option) (getter.Getter, error) {
+			return h, nil
+		},
+	}
+}
+
+// Get downloads the content of href. The getter options are ignored, the
+// settings come from the chart repository entry.
+func (h *httpGetter) Get(href string, options ...getter.Option) (*bytes.Buffer, error) {
+	var buf *bytes.Buffer
+	err := h.retry(href, func() error {
+		var err error
+		buf, err = h.get(href)
+		return err
+	})
+	return buf, err
+}
+
+// retry calls fn until it succeeds, fails with an error that cannot be
+// retried or the number of retries is exhausted.
+func (h *httpGetter) retry(href string, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= h.retries || !retryable(err) {
+			return err
+		}
+		wait := h.wait(attempt, err)
+		if h.verbose {
+			h.logger.Printf("retrying %s in %s (%d/%d): %s", href, wait.Round(time.Millisecond), attempt+1, h.retries, err)
+		}
+		time.Sleep(wait)
+	}
+}
+
+func (h *httpGetter) get(href string) (*bytes.Buffer, error) {
+	req, err := h.newRequest(http.MethodGet, href)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{href, resp.Status, resp.StatusCode, resp.Header.Get("Retry-After")}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	_, err = io.Copy(buf, resp.Body)
+	return buf, err
+}
+
+// Download downloads the content of href into partPath. When partPath holds
+// the beginning of the content, left by an interrupted download, only the
+// rest is requested with a Range request, conditional with If-Range on the
+// content being unchanged since. The download starts over when the server
+// does not support them, when the content changed or when the part file does
+// not match the content. Every retry resumes the download.
+func (h *httpGetter) Download(href string, partPath string) error {
+	return h.retry(href, func() error {
+		return h.download(href, partPath)
+	})
+}
+
+func (h *httpGetter) download(href string, partPath string) error {
+	validatorPath := partPath + validatorExtension
+	var offset int64
+	if info, err := os.Stat(partPath); err == nil {
+		offset = info.Size()
+	}
+
+	req, err := h.newRequest(http.MethodGet, href)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if validator, err := os.ReadFile(validatorPath); err == nil {
+			req.Header.Set("If-Range", string(validator))
+		}
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch {
+	case offset > 0 && resp.StatusCode == http.StatusPartialContent:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			return h.restart(href, partPath, "unexpected content range "+resp.Header.Get("Content-Range"))
+		}
+		if h.verbose {
+			h.logger.Printf("resuming download of %s from byte %d", href, offset)
+		}
+		flags |= os.O_APPEND
+	case offset > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// Already complete when the part file has the size of the content,
+		// its digest is verified afterwards
+		if resp.Header.Get("Content-Range") != fmt.Sprintf("bytes */%d", offset) {
+			return h.restart(href, partPath, "part file larger than the content")
+		}
+		os.Remove(validatorPath)
+		return nil
+	case resp.StatusCode == http.StatusOK:
+		flags |= os.O_TRUNC
+		err = writeValidator(validatorPath, resp.Header)
+		if err != nil {
+			return err
+		}
+	default:
+		return &statusError{href, resp.Status, resp.StatusCode, resp.Header.Get("Retry-After")}
+	}
+
+	f, err := os.OpenFile(partPath, flags, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, resp.Body)
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	os.Remove(validatorPath)
+	return nil
+}
+
+// restart discards the part file and downloads the content from the beginning.
+func (h *httpGetter) restart(href string, partPath string, reason string) error {
+	if h.verbose {
+		h.logger.Printf("restarting download of %s: %s", href, reason)
+	}
+	os.Remove(partPath)
+	os.Remove(partPath + validatorExtension)
+	return h.download(href, partPath)
+}
+
+// writeValidator keeps the strong ETag of the response, or its Last-Modified
+// date, to resume the download only if the content is unchanged.
+func writeValidator(validatorPath string, header http.Header) error {
+	validator := header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = header.Get("Last-Modified")
+	}
+	if validator == "" {
+		os.Remove(validatorPath)
+		return nil
+	}
+	return os.WriteFile(validatorPath, []byte(validator), 0644)
+}
+
+// Size returns the size of the content of href from a HEAD request, -1 when
+// the server does not tell it.
+func (h *httpGetter) Size(href string) (int64, error) {
+	size := int64(-1)
+	err := h.retry(href, func() error {
+		req, err := h.newRequest(http.MethodHead, href)
+		if err != nil {
+			return err
+		}
+		resp, err := h.client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return &statusError{href, resp.Status, resp.StatusCode, resp.Header.Get("Retry-After")}
+		}
+		size = resp.ContentLength
+		return nil
+	})
+	return size, err
+}
+
+func (h *httpGetter) newRequest(method string, href string) (*http.Request, error) {
+	req, err := http.NewRequest(method, href, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	// Only send the credentials to the host of the chart repository, unless
+	// the repository asks to pass them to all the hosts.
+	repoURL, err := url.Parse(h.config.URL)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to parse repository URL")
+	}
+	if h.config.PassCredentialsAll || (repoURL.Scheme == req.URL.Scheme && repoURL.Host == req.URL.Host) {
+		if h.config.Username != "" && h.config.Password != "" {
+			req.SetBasicAuth(h.config.Username, h.config.Password)
+		}
+	}
+	return req, nil
+}
+
+// wait returns how long to wait before the next attempt. The delay asked by
+// the server with Retry-After is capped like the backoff.
+func (h *httpGetter) wait(attempt int, err error) time.Duration {
+	if se, ok := err.(*statusError); ok && se.retryAfter != "" {
+		if seconds, err := strconv.Atoi(se.retryAfter); err == nil && seconds >= 0 {
+			if seconds > int(maxRetryBackoff/time.Second) {
+				return maxRetryBackoff
+			}
+			return time.Duration(seconds) * time.Second
+		}
+		if date, err := http.ParseTime(se.retryAfter); err == nil {
+			d := time.Until(date)
+			if d > maxRetryBackoff {
+				return maxRetryBackoff
+			}
+			if d > 0 {
+				return d
+			}
+			return 0
+		}
+	}
+
+	if h.backoff <= 0 {
+		return 0
+	}
+	backoff := h.backoff << uint(attempt)
+	if backoff <= 0 || backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
+	}
+	// Equal jitter: half of the backoff, plus a random part of the other half
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// retryable reports if a failed request may succeed when retried: network
+// errors, timeouts, truncated responses, rate limiting and server errors.
+// Other errors, like an invalid URL, an unsupported scheme, an untrusted
+// certificate or a file that cannot be written, are not retried.
+func retryable(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.statusCode == http.StatusTooManyRequests || se.statusCode == http.StatusRequestTimeout || se.statusCode >= 500
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	// a *url.Error is a net.Error whatever it wraps, only its cause tells
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		err = ue.Err
+	}
+	var oe *net.OpError
+	if errors.As(err, &oe) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
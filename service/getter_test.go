@@ -0,0 +1,249 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+func Test_httpGetter_Get(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int32
+		status       int
+		retries      int
+		wantErr      bool
+		wantRequests int32
+	}{
+		{"1", 0, http.StatusBadGateway, 0, false, 1},
+		{"2", 2, http.StatusBadGateway, 2, false, 3},
+		{"3", 2, http.StatusBadGateway, 1, true, 2},
+		{"4", 1, http.StatusTooManyRequests, 1, false, 2},
+		{"5", 1, http.StatusNotFound, 3, true, 1},
+		{"6", 1, http.StatusServiceUnavailable, 0, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&requests, 1) <= tt.failures {
+					w.Header().Set("Retry-After", "0")
+					w.WriteHeader(tt.status)
+					return
+				}
+				w.Write([]byte("chart"))
+			}))
+			defer svr.Close()
+
+			h, err := newHTTPGetter(repo.Entry{URL: svr.URL}, tt.retries, time.Hour, true, fakeLogger)
+			if err != nil {
+				t.Fatalf("newHTTPGetter() error = %v", err)
+			}
+			got, err := h.Get(svr.URL + "/chart-1.0.0.tgz")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("httpGetter.Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got.String() != "chart" {
+				t.Errorf("httpGetter.Get() = %v, want %v", got.String(), "chart")
+			}
+			if requests != tt.wantRequests {
+				t.Errorf("httpGetter.Get() requests = %v, want %v", requests, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func Test_httpGetter_Download(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	content := bytes.Repeat([]byte("chart content "), 1000)
+	tests := []struct {
+		name         string
+		part         []byte
+		validator    string
+		ignoreRange  bool
+		interrupt    bool
+		retries      int
+		wantErr      bool
+		wantRequests int32
+	}{
+		{"1", nil, "", false, false, 0, false, 1},
+		{"2", content[:100], "", false, false, 0, false, 1},
+		{"3", content[:100], "", true, false, 0, false, 1},
+		{"4", nil, "", false, true, 1, false, 2},
+		{"5", nil, "", false, true, 0, true, 1},
+		{"6", content, "", false, false, 0, false, 1},
+		{"7", content[:100], `"v2"`, false, false, 0, false, 1},
+		{"8", bytes.Repeat([]byte("old"), 100), `"v1"`, false, false, 0, false, 1},
+		{"9", append(append([]byte{}, content...), "extra"...), "", false, false, 0, false, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&requests, 1) == 1 && tt.interrupt {
+					// Drop the connection halfway through the content
+					w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+					w.Write(content[:len(content)/2])
+					panic(http.ErrAbortHandler)
+				}
+				if tt.ignoreRange {
+					r.Header.Del("Range")
+				}
+				w.Header().Set("ETag", `"v2"`)
+				http.ServeContent(w, r, "chart-1.0.0.tgz", time.Time{}, bytes.NewReader(content))
+			}))
+			defer svr.Close()
+
+			partPath := path.Join(dir, tt.name+".part")
+			if tt.part != nil {
+				err := os.WriteFile(partPath, tt.part, 0644)
+				if err != nil {
+					t.Fatalf("writing part file: %s", err)
+				}
+			}
+			if tt.validator != "" {
+				os.WriteFile(partPath+validatorExtension, []byte(tt.validator), 0644)
+			}
+			h, err := newHTTPGetter(repo.Entry{URL: svr.URL}, tt.retries, 0, false, fakeLogger)
+			if err != nil {
+				t.Fatalf("newHTTPGetter() error = %v", err)
+			}
+			err = h.Download(svr.URL+"/chart-1.0.0.tgz", partPath)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("httpGetter.Download() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if requests != tt.wantRequests {
+				t.Errorf("httpGetter.Download() requests = %v, want %v", requests, tt.wantRequests)
+			}
+			got, _ := os.ReadFile(partPath)
+			if tt.wantErr {
+				if len(got) != len(content)/2 {
+					t.Errorf("httpGetter.Download() kept %d bytes, want %d", len(got), len(content)/2)
+				}
+				return
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("httpGetter.Download() content of %d bytes differs from the %d bytes served", len(got), len(content))
+			}
+			if fileExists(partPath + validatorExtension) {
+				t.Errorf("httpGetter.Download() kept the validator of a complete download")
+			}
+		})
+	}
+}
+
+func Test_httpGetter_Size(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("httpGetter.Size() method = %s, want %s", r.Method, http.MethodHead)
+		}
+		if r.URL.Path == "/missing.tgz" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", "1234")
+	}))
+	defer svr.Close()
+	tests := []struct {
+		name    string
+		file    string
+		want    int64
+		wantErr bool
+	}{
+		{"1", "/chart-1.0.0.tgz", 1234, false},
+		{"2", "/missing.tgz", -1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := newHTTPGetter(repo.Entry{URL: svr.URL}, 0, 0, false, fakeLogger)
+			if err != nil {
+				t.Fatalf("newHTTPGetter() error = %v", err)
+			}
+			got, err := h.Size(svr.URL + tt.file)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("httpGetter.Size() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("httpGetter.Size() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_httpGetter_wait(t *testing.T) {
+	h := &httpGetter{backoff: time.Second}
+	tests := []struct {
+		name    string
+		attempt int
+		err     error
+		min     time.Duration
+		max     time.Duration
+	}{
+		{"1", 0, errors.New("connection reset"), 500 * time.Millisecond, time.Second},
+		{"2", 3, errors.New("connection reset"), 4 * time.Second, 8 * time.Second},
+		{"3", 30, errors.New("connection reset"), maxRetryBackoff / 2, maxRetryBackoff},
+		{"4", 0, &statusError{statusCode: http.StatusServiceUnavailable, retryAfter: "7"}, 7 * time.Second, 7 * time.Second},
+		{"5", 0, &statusError{statusCode: http.StatusServiceUnavailable, retryAfter: "Mon, 02 Jan 2006 15:04:05 GMT"}, 0, 0},
+		{"6", 0, &statusError{statusCode: http.StatusServiceUnavailable, retryAfter: "soon"}, 500 * time.Millisecond, time.Second},
+		{"7", 0, &statusError{statusCode: http.StatusServiceUnavailable, retryAfter: "86400"}, maxRetryBackoff, maxRetryBackoff},
+		{"8", 0, &statusError{statusCode: http.StatusServiceUnavailable, retryAfter: time.Now().Add(24 * time.Hour).UTC().Format(http.TimeFormat)}, maxRetryBackoff, maxRetryBackoff},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := h.wait(tt.attempt, tt.err); got < tt.min || got > tt.max {
+				t.Errorf("httpGetter.wait() = %v, want between %v and %v", got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func Test_retryable(t *testing.T) {
+	_, parseErr := http.NewRequest(http.MethodGet, "http://[::1", nil)
+	_, schemeErr := http.Get("ftp://localhost/chart.tgz")
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"1", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, true},
+		{"2", &statusError{statusCode: http.StatusBadGateway}, true},
+		{"3", &statusError{statusCode: http.StatusTooManyRequests}, true},
+		{"4", &statusError{statusCode: http.StatusNotFound}, false},
+		{"5", &statusError{statusCode: http.StatusUnauthorized}, false},
+		{"6", io.ErrUnexpectedEOF, true},
+		{"7", &os.PathError{Op: "open", Path: "/folder/chart.tgz.part", Err: os.ErrPermission}, false},
+		{"8", &url.Error{Op: "Get", URL: "http://localhost", Err: &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset")}}, true},
+		{"9", errors.New("invalid URL"), false},
+		{"10", &url.Error{Op: "Get", URL: "https://localhost", Err: x509.UnknownAuthorityError{}}, false},
+		{"11", parseErr, false},
+		{"12", schemeErr, false},
+		{"13", &url.Error{Op: "Get", URL: "http://localhost", Err: context.DeadlineExceeded}, true},
+		{"14", &os.SyscallError{Syscall: "read", Err: syscall.ECONNRESET}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryable(tt.err); got != tt.want {
+				t.Errorf("retryable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/pkg/errors"
+)
+
+// errImageCopyUnsupported is returned by the copies of images when built
+// without the containers_image_openpgp tag.
+var errImageCopyUnsupported = errors.New("copying container images needs helm-mirror built with the containers_image_openpgp tag")
+
+// parseImageReference returns the full reference of an image, tagged latest
+// when it has neither tag nor digest.
+func parseImageReference(image string) (reference.Named, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return nil, errors.Wrapf(err, "not a valid image reference")
+	}
+	return reference.TagNameOnly(named), nil
+}
+
+// copyImage copies an image, or every image of a manifest list, between
+// registries. The manifests are kept as published, so that the image digests
+// are unchanged. With skipExisting nothing is copied when the destination
+// already holds the same manifest. It returns whether the image was copied.
+func copyImage(ctx context.Context, sys *types.SystemContext, srcRef types.ImageReference, destRef types.ImageReference, skipExisting bool) (bool, error) {
+	if skipExisting {
+		src, err := srcRef.NewImageSource(ctx, sys)
+		if err != nil {
+			return false, err
+		}
+		topManifest, _, err := src.GetManifest(ctx, nil)
+		src.Close()
+		if err != nil {
+			return false, err
+		}
+		if hasManifest(ctx, sys, destRef, topManifest) {
+			return false, nil
+		}
+	}
+	err := copyAllImages(ctx, sys, srcRef, destRef, false)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// hasManifest reports whether the image of the reference has the manifest.
+// An image that cannot be read is reported missing.
+func hasManifest(ctx context.Context, sys *types.SystemContext, ref types.ImageReference, m []byte) bool {
+	src, err := ref.NewImageSource(ctx, sys)
+	if err != nil {
+		return false
+	}
+	defer src.Close()
+	existing, _, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return false
+	}
+	want, err := manifest.Digest(m)
+	if err != nil {
+		return false
+	}
+	matches, err := manifest.MatchesDigest(existing, want)
+	return err == nil && matches
+}
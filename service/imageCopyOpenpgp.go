@@ -0,0 +1,58 @@
+//go:build containers_image_openpgp
+
+package service
+
+import (
+	"context"
+	"os"
+
+	"github.com/containers/image/v5/copy"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/types"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ImageCopySupported reports whether helm-mirror was built able to copy
+// container images.
+const ImageCopySupported = true
+
+// newPolicyContext returns the signature policy of the images copied, read
+// from the policy.json of containers/image. Any image is accepted when the
+// system has no policy file. The context must be destroyed once done with.
+func newPolicyContext(sys *types.SystemContext) (*signature.PolicyContext, error) {
+	policy, err := signature.DefaultPolicy(sys)
+	if os.IsNotExist(err) {
+		policy = &signature.Policy{Default: signature.PolicyRequirements{signature.NewPRInsecureAcceptAnything()}}
+	} else if err != nil {
+		return nil, err
+	}
+	return signature.NewPolicyContext(policy)
+}
+
+// copyAllImages copies an image, or every image of a manifest list, with
+// their configurations and layers, the blobs already in the destination
+// being reused. With toOCI the manifests are converted to OCI media types and
+// the signatures dropped, as an OCI image layout needs, otherwise they are
+// kept as published so that the image digests are unchanged. A policy
+// context is created for every copy, as a context cannot be shared by
+// concurrent copies.
+func copyAllImages(ctx context.Context, sys *types.SystemContext, srcRef types.ImageReference, destRef types.ImageReference, toOCI bool) error {
+	policy, err := newPolicyContext(sys)
+	if err != nil {
+		return err
+	}
+	defer policy.Destroy()
+	options := &copy.Options{
+		SourceCtx:          sys,
+		DestinationCtx:     sys,
+		ImageListSelection: copy.CopyAllImages,
+	}
+	if toOCI {
+		options.ForceManifestMIMEType = imgspecv1.MediaTypeImageManifest
+		options.RemoveSignatures = true
+	} else {
+		options.PreserveDigests = true
+	}
+	_, err = copy.Image(ctx, policy, destRef, srcRef, options)
+	return err
+}
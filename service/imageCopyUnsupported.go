@@ -0,0 +1,21 @@
+//go:build !containers_image_openpgp
+
+package service
+
+import (
+	"context"
+
+	"github.com/containers/image/v5/types"
+)
+
+// ImageCopySupported reports whether helm-mirror was built able to copy
+// container images. The copies of containers/image verify the signatures
+// with gpgme through cgo, unless built with the containers_image_openpgp tag
+// for the OpenPGP implementation in Go. Without the tag helm-mirror builds
+// without the copies, for the gpgme library not to be needed.
+const ImageCopySupported = false
+
+// copyAllImages fails, helm-mirror being built without the copies of images.
+func copyAllImages(ctx context.Context, sys *types.SystemContext, srcRef types.ImageReference, destRef types.ImageReference, toOCI bool) error {
+	return errImageCopyUnsupported
+}
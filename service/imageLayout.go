@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"log"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/types"
+)
+
+// imageLayoutIndexName is the name of the index file of an OCI image layout
+const imageLayoutIndexName = "index.json"
+
+// imageLayoutCopier copies container images from their registries into an
+// OCI image layout. The blobs are stored once in the layout, whichever
+// images share them. The images are converted to OCI media types, those
+// of Docker included, as an OCI image layout holds nothing else.
+type imageLayoutCopier struct {
+	layout       string
+	sys          *types.SystemContext
+	verbose      bool
+	ignoreErrors bool
+	logger       *log.Logger
+	copied       int
+	failed       int
+}
+
+// newImageLayoutCopier returns a copier into the OCI image layout folder. The
+// credentials of the registries are read from the auth files of Docker and
+// Podman. Insecure skips the TLS verification of the registries, and falls
+// back to plain HTTP.
+func newImageLayoutCopier(layoutFolder string, insecure bool, verbose bool, ignoreErrors bool, logger *log.Logger) *imageLayoutCopier {
+	return &imageLayoutCopier{
+		layout: layoutFolder,
+		sys: &types.SystemContext{
+			DockerInsecureSkipTLSVerify: types.NewOptionalBool(insecure),
+		},
+		verbose:      verbose,
+		ignoreErrors: ignoreErrors,
+		logger:       logger,
+	}
+}
+
+// copyImages copies the images into the layout, each one listed in the index
+// of the layout under its full reference. An image failing to copy is
+// skipped with ignoreErrors.
+func (c *imageLayoutCopier) copyImages(images []string) error {
+	for _, image := range images {
+		err := c.copyImage(context.Background(), image)
+		if err != nil {
+			if c.ignoreErrors {
+				c.logger.Printf("WARNING: copying image %s - %s", image, err)
+				c.failed++
+				continue
+			}
+			c.logger.Printf("error: copying image %s: %s", image, err)
+			return err
+		}
+		c.copied++
+	}
+	c.logger.Printf("images: %d copied, %d failed", c.copied, c.failed)
+	return nil
+}
+
+// copyImage copies an image, or every image of a manifest list, into the
+// layout, listed under its full reference. The signatures are dropped, the
+// layout having no place for them.
+func (c *imageLayoutCopier) copyImage(ctx context.Context, image string) error {
+	named, err := parseImageReference(image)
+	if err != nil {
+		return err
+	}
+	srcRef, err := docker.NewReference(named)
+	if err != nil {
+		return err
+	}
+	destRef, err := layout.NewReference(c.layout, named.String())
+	if err != nil {
+		return err
+	}
+	err = copyAllImages(ctx, c.sys, srcRef, destRef, true)
+	if err != nil {
+		return err
+	}
+	if c.verbose {
+		c.logger.Printf("copied image %s", named)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/kplachkov/helm-mirror/fixtures"
+)
+
+func Test_imageLayoutCopier_copyImages(t *testing.T) {
+	if !ImageCopySupported {
+		t.Skip(errImageCopyUnsupported)
+	}
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	host, err := fixtures.StartRegistry()
+	if err != nil {
+		t.Fatalf("starting registry: %s", err)
+	}
+	shared := []byte("shared layer")
+	_, err = fixtures.PushImage(host, "app", "1.0", shared, []byte("app layer"))
+	if err != nil {
+		t.Fatalf("pushing image: %s", err)
+	}
+	_, err = fixtures.PushImage(host, "tools/sidecar", "2.0", shared)
+	if err != nil {
+		t.Fatalf("pushing image: %s", err)
+	}
+	_, err = fixtures.PushDockerImage(host, "legacy", "1.0", shared, []byte("legacy layer"))
+	if err != nil {
+		t.Fatalf("pushing image: %s", err)
+	}
+	amd64, err := fixtures.PushDockerImage(host, "multi", "amd64", []byte("amd64 layer"))
+	if err != nil {
+		t.Fatalf("pushing image: %s", err)
+	}
+	arm64, err := fixtures.PushDockerImage(host, "multi", "arm64", []byte("arm64 layer"))
+	if err != nil {
+		t.Fatalf("pushing image: %s", err)
+	}
+	amd64.Platform = &imgspecv1.Platform{Architecture: "amd64", OS: "linux"}
+	arm64.Platform = &imgspecv1.Platform{Architecture: "arm64", OS: "linux"}
+	_, err = fixtures.PushDockerList(host, "multi", "1.0", amd64, arm64)
+	if err != nil {
+		t.Fatalf("pushing list: %s", err)
+	}
+
+	tests := []struct {
+		name         string
+		images       []string
+		insecure     bool
+		ignoreErrors bool
+		wantRefs     []string
+		wantBlobs    int
+		wantErr      bool
+	}{
+		{"1", []string{host + "/app:1.0", host + "/tools/sidecar:2.0"}, true, false, []string{host + "/app:1.0", host + "/tools/sidecar:2.0"}, 6, false},
+		{"2", []string{host + "/app:1.0", host + "/missing:1.0"}, true, false, nil, 0, true},
+		{"3", []string{host + "/app:1.0", host + "/missing:1.0"}, true, true, []string{host + "/app:1.0"}, 4, false},
+		{"4", []string{host + "/app"}, true, false, nil, 0, true},
+		{"5", []string{"Not A Reference"}, true, false, nil, 0, true},
+		{"6", []string{host + "/app:1.0"}, false, false, nil, 0, true},
+		{"7", []string{host + "/legacy:1.0", host + "/multi:1.0"}, true, false, []string{host + "/legacy:1.0", host + "/multi:1.0"}, 11, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			layout := path.Join(dir, tt.name)
+			c := newImageLayoutCopier(layout, tt.insecure, false, tt.ignoreErrors, fakeLogger)
+			if err := c.copyImages(tt.images); (err != nil) != tt.wantErr {
+				t.Fatalf("imageLayoutCopier.copyImages() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			content, err := os.ReadFile(path.Join(layout, imageLayoutIndexName))
+			if err != nil {
+				t.Fatalf("reading layout index: %s", err)
+			}
+			var index imgspecv1.Index
+			json.Unmarshal(content, &index)
+			var refs []string
+			for _, m := range index.Manifests {
+				refs = append(refs, m.Annotations[imgspecv1.AnnotationRefName])
+			}
+			sort.Strings(refs)
+			if !reflect.DeepEqual(refs, tt.wantRefs) {
+				t.Errorf("imageLayoutCopier.copyImages() refs = %v, want %v", refs, tt.wantRefs)
+			}
+			for _, m := range index.Manifests {
+				for _, mediaType := range layoutMediaTypes(t, layout, m) {
+					if !strings.HasPrefix(mediaType, "application/vnd.oci.") {
+						t.Errorf("imageLayoutCopier.copyImages() stored media type %s", mediaType)
+					}
+				}
+			}
+			// the shared layer is stored once
+			blobs, _ := os.ReadDir(path.Join(layout, "blobs", "sha256"))
+			if len(blobs) != tt.wantBlobs {
+				t.Errorf("imageLayoutCopier.copyImages() stored %d blobs, want %d", len(blobs), tt.wantBlobs)
+			}
+			for _, b := range blobs {
+				if strings.HasPrefix(b.Name(), "oci-put-blob") {
+					t.Errorf("imageLayoutCopier.copyImages() left temporary blob %s", b.Name())
+				}
+			}
+		})
+	}
+}
+
+// layoutMediaTypes returns the media types of the descriptor and of all the
+// descriptors it refers to in the layout.
+func layoutMediaTypes(t *testing.T, layout string, d imgspecv1.Descriptor) []string {
+	content, err := os.ReadFile(path.Join(layout, "blobs", d.Digest.Algorithm().String(), d.Digest.Encoded()))
+	if err != nil {
+		t.Fatalf("reading blob %s: %s", d.Digest, err)
+	}
+	var m struct {
+		MediaType string                 `json:"mediaType"`
+		Config    *imgspecv1.Descriptor  `json:"config"`
+		Layers    []imgspecv1.Descriptor `json:"layers"`
+		Manifests []imgspecv1.Descriptor `json:"manifests"`
+	}
+	json.Unmarshal(content, &m)
+	mediaTypes := []string{d.MediaType, m.MediaType}
+	if m.Config != nil {
+		mediaTypes = append(mediaTypes, m.Config.MediaType)
+	}
+	for _, l := range m.Layers {
+		mediaTypes = append(mediaTypes, l.MediaType)
+	}
+	for _, instance := range m.Manifests {
+		mediaTypes = append(mediaTypes, layoutMediaTypes(t, layout, instance)...)
+	}
+	return mediaTypes
+}
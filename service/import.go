@@ -0,0 +1,302 @@
+package service
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/repo"
+	"sigs.k8s.io/yaml"
+)
+
+// ImportServiceInterface defines an Import service
+type ImportServiceInterface interface {
+	Import() error
+}
+
+// ImportService structure definition
+type ImportService struct {
+	archive    string
+	folder     string
+	newRootURL string
+	verbose    bool
+	logger     *log.Logger
+}
+
+// NewImportService return a new instance of ImportService
+func NewImportService(archive string, folder string, newRootURL string, verbose bool, logger *log.Logger) ImportServiceInterface {
+	return &ImportService{
+		archive:    archive,
+		folder:     folder,
+		newRootURL: newRootURL,
+		verbose:    verbose,
+		logger:     logger,
+	}
+}
+
+// Import unpacks a bundle made by Export into the folder. The archive is
+// extracted into a temporary folder and checked against its manifest first,
+// nothing being written into the folder when a file is missing, altered or
+// not listed. The charts of the bundle are then added to the index file of
+// the folder, their URLs under the new root URL, and its container images to
+// the OCI image layout of the images folder.
+func (i *ImportService) Import() error {
+	err := os.MkdirAll(i.folder, 0744)
+	if err != nil {
+		i.logger.Printf("error: cannot create destination folder: %s", err)
+		return err
+	}
+	staging, err := os.MkdirTemp(i.folder, ".import-*")
+	if err != nil {
+		i.logger.Printf("error: cannot create staging folder: %s", err)
+		return err
+	}
+	defer os.RemoveAll(staging)
+
+	extracted, err := extractBundle(i.archive, staging)
+	if err != nil {
+		i.logger.Printf("error: cannot extract archive %s: %s", i.archive, err)
+		return err
+	}
+	manifest, err := verifyBundle(staging, extracted)
+	if err != nil {
+		i.logger.Printf("error: archive %s failed verification: %s", i.archive, err)
+		return err
+	}
+
+	bundle, err := repo.LoadIndexFile(path.Join(staging, indexFileName))
+	if err != nil {
+		i.logger.Printf("error: cannot load index file of archive %s: %s", i.archive, err)
+		return err
+	}
+	index, err := loadExistingIndex(i.folder)
+	if err != nil {
+		i.logger.Printf("error: %s", err)
+		return err
+	}
+	if index == nil {
+		index = repo.NewIndexFile()
+	}
+	for name, versions := range bundle.Entries {
+		for _, v := range versions {
+			chartFile, err := bundleChartPath(v)
+			if err == nil && !extracted[chartFile] {
+				err = errors.Errorf("chart file %s not in the archive", chartFile)
+			}
+			if err != nil {
+				i.logger.Printf("error: importing chart %s(%s): %s", name, v.Version, err)
+				return err
+			}
+			v.URLs = []string{joinRootURL(i.newRootURL, chartFile)}
+			replaceChart(index, name, v)
+		}
+	}
+	index.SortEntries()
+
+	for _, f := range manifest.Files {
+		if f.Name == indexFileName {
+			continue
+		}
+		target := path.Join(i.folder, f.Name)
+		err = os.MkdirAll(path.Dir(target), 0744)
+		if err == nil && f.Name == path.Join(bundleImagesFolder, imageLayoutIndexName) && fileExists(target) {
+			err = mergeImageLayoutIndex(target, path.Join(staging, f.Name))
+		} else if err == nil {
+			err = os.Rename(path.Join(staging, f.Name), target)
+		}
+		if err != nil {
+			i.logger.Printf("error: cannot write file %s: %s", target, err)
+			return err
+		}
+		if i.verbose {
+			i.logger.Printf("imported %s", f.Name)
+		}
+	}
+	err = writeIndexFile(i.folder, index)
+	if err != nil {
+		i.logger.Printf("error: cannot write index file: %s", err)
+		return err
+	}
+	i.logger.Printf("imported %d charts into %s", countCharts(bundle), i.folder)
+	return nil
+}
+
+// replaceChart adds the chart version to the index under the name, in place
+// of the one already listed.
+func replaceChart(index *repo.IndexFile, name string, chart *repo.ChartVersion) {
+	versions := index.Entries[name]
+	for j, v := range versions {
+		if v.Version == chart.Version {
+			versions[j] = chart
+			return
+		}
+	}
+	index.Entries[name] = append(versions, chart)
+}
+
+// extractBundle extracts the regular files of a gzipped tar archive into the
+// folder. It returns the names of the files extracted, and fails on a file
+// outside of the folder or a link.
+func extractBundle(archive string, folder string) (map[string]bool, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	extracted := make(map[string]bool)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return extracted, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag == tar.TypeDir {
+			continue
+		}
+		name := path.Clean(header.Name)
+		if header.Typeflag != tar.TypeReg || !validBundlePath(name) {
+			return nil, errors.Errorf("unexpected entry %s", header.Name)
+		}
+		if extracted[name] {
+			return nil, errors.Errorf("duplicate entry %s", header.Name)
+		}
+
+		target := path.Join(folder, name)
+		err = os.MkdirAll(path.Dir(target), 0744)
+		if err != nil {
+			return nil, err
+		}
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(out, tr)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, err
+		}
+		extracted[name] = true
+	}
+}
+
+// verifyBundle checks the files extracted into the folder against the
+// manifest of the bundle: every file listed is present with its size and
+// checksum, and every file extracted is listed.
+func verifyBundle(folder string, extracted map[string]bool) (*bundleManifest, error) {
+	if !extracted[bundleManifestName] {
+		return nil, errors.Errorf("no %s in the archive", bundleManifestName)
+	}
+	content, err := os.ReadFile(path.Join(folder, bundleManifestName))
+	if err != nil {
+		return nil, err
+	}
+	manifest := &bundleManifest{}
+	err = yaml.UnmarshalStrict(content, manifest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot parse %s", bundleManifestName)
+	}
+
+	listed := map[string]bool{bundleManifestName: true}
+	for _, f := range manifest.Files {
+		if !extracted[f.Name] {
+			return nil, errors.Errorf("file %s is missing", f.Name)
+		}
+		if listed[f.Name] {
+			return nil, errors.Errorf("file %s is listed twice", f.Name)
+		}
+		listed[f.Name] = true
+		size, sum, err := fileChecksum(path.Join(folder, f.Name))
+		if err != nil {
+			return nil, err
+		}
+		if size != f.Size || sum != f.SHA256 {
+			return nil, errors.Errorf("checksum mismatch for file %s", f.Name)
+		}
+	}
+	for name := range extracted {
+		if !listed[name] {
+			return nil, errors.Errorf("file %s is not listed in %s", name, bundleManifestName)
+		}
+	}
+	if !listed[indexFileName] {
+		return nil, errors.Errorf("no %s in the archive", indexFileName)
+	}
+	return manifest, nil
+}
+
+// fileChecksum returns the size and the SHA-256 of a file.
+func fileChecksum(name string) (int64, string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// mergeImageLayoutIndex adds the images of an imported OCI image layout index
+// to the existing one, an imported image replacing the one with the same
+// reference.
+func mergeImageLayoutIndex(existingPath string, importedPath string) error {
+	var existing, imported imgspecv1.Index
+	for _, index := range []struct {
+		path  string
+		index *imgspecv1.Index
+	}{{existingPath, &existing}, {importedPath, &imported}} {
+		content, err := os.ReadFile(index.path)
+		if err != nil {
+			return err
+		}
+		err = json.Unmarshal(content, index.index)
+		if err != nil {
+			return errors.Wrapf(err, "cannot parse %s", index.path)
+		}
+	}
+
+	names := make(map[string]bool)
+	digests := make(map[digest.Digest]bool)
+	for _, m := range imported.Manifests {
+		names[m.Annotations[imgspecv1.AnnotationRefName]] = true
+		digests[m.Digest] = true
+	}
+	var kept []imgspecv1.Descriptor
+	for _, m := range existing.Manifests {
+		name := m.Annotations[imgspecv1.AnnotationRefName]
+		if (name != "" && names[name]) || (name == "" && digests[m.Digest]) {
+			continue
+		}
+		kept = append(kept, m)
+	}
+	existing.Manifests = append(kept, imported.Manifests...)
+
+	content, err := json.Marshal(existing)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(existingPath, content)
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+func TestNewImportService(t *testing.T) {
+	want := &ImportService{archive: "/charts.tgz", folder: "/folder", newRootURL: "https://mirror.local.lan/charts", logger: fakeLogger}
+	if got := NewImportService("/charts.tgz", "/folder", "https://mirror.local.lan/charts", false, fakeLogger); !reflect.DeepEqual(got, want) {
+		t.Errorf("NewImportService() = %v, want %v", got, want)
+	}
+}
+
+func TestImportService_Import(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := path.Join(dir, "source")
+	os.MkdirAll(source, 0744)
+	packageTestChart(t, source, "a", "1.0.0")
+	packageTestChart(t, source, "b", "1.0.0")
+	os.WriteFile(path.Join(source, "a-1.0.0.tgz.prov"), []byte("signature"), 0644)
+	writeTestIndex(t, source, "")
+	archive := path.Join(dir, "charts.tgz")
+	err = NewExportService(source, archive, ExportOptions{}, false, false, fakeLogger).Export()
+	if err != nil {
+		t.Fatalf("exporting charts: %s", err)
+	}
+	files := readTestBundle(t, archive)
+
+	tests := []struct {
+		name       string
+		newRootURL string
+		tamper     func(files map[string][]byte)
+		wantURLs   map[string]string
+		wantErr    bool
+	}{
+		{"1", "", nil, map[string]string{"a": "a-1.0.0.tgz", "b": "b-1.0.0.tgz", "old": "old-1.0.0.tgz"}, false},
+		{"2", "https://mirror.local.lan/charts/", nil, map[string]string{"a": "https://mirror.local.lan/charts/a-1.0.0.tgz", "b": "https://mirror.local.lan/charts/b-1.0.0.tgz", "old": "old-1.0.0.tgz"}, false},
+		{"3", "", func(files map[string][]byte) { files["a-1.0.0.tgz.prov"] = []byte("forged") }, nil, true},
+		{"4", "", func(files map[string][]byte) { delete(files, "b-1.0.0.tgz") }, nil, true},
+		{"5", "", func(files map[string][]byte) { files["extra.tgz"] = []byte("extra") }, nil, true},
+		{"6", "", func(files map[string][]byte) { files["../escape.tgz"] = []byte("escape") }, nil, true},
+		{"7", "", func(files map[string][]byte) { delete(files, bundleManifestName) }, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workDir := path.Join(dir, tt.name)
+			os.MkdirAll(workDir, 0744)
+			existing := repo.NewIndexFile()
+			existing.MustAdd(&chart.Metadata{APIVersion: chart.APIVersionV2, Name: "old", Version: "1.0.0"}, "old-1.0.0.tgz", "", "digest")
+			existing.WriteFile(path.Join(workDir, indexFileName), 0644)
+
+			bundle := archive
+			if tt.tamper != nil {
+				tampered := make(map[string][]byte)
+				for name, content := range files {
+					tampered[name] = content
+				}
+				tt.tamper(tampered)
+				bundle = path.Join(dir, tt.name+".tgz")
+				writeTestBundle(t, bundle, tampered)
+			}
+
+			i := NewImportService(bundle, workDir, tt.newRootURL, false, fakeLogger)
+			if err := i.Import(); (err != nil) != tt.wantErr {
+				t.Fatalf("ImportService.Import() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				entries, _ := os.ReadDir(workDir)
+				if len(entries) != 1 {
+					t.Errorf("ImportService.Import() left %d files in the folder, want only the index file", len(entries))
+				}
+				return
+			}
+
+			for _, f := range []string{"a-1.0.0.tgz", "a-1.0.0.tgz.prov", "b-1.0.0.tgz"} {
+				if !fileExists(path.Join(workDir, f)) {
+					t.Errorf("ImportService.Import() did not import %s", f)
+				}
+			}
+			index, err := repo.LoadIndexFile(path.Join(workDir, indexFileName))
+			if err != nil {
+				t.Fatalf("loading index: %s", err)
+			}
+			urls := make(map[string]string)
+			for name, versions := range index.Entries {
+				urls[name] = versions[0].URLs[0]
+			}
+			if !reflect.DeepEqual(urls, tt.wantURLs) {
+				t.Errorf("ImportService.Import() URLs = %v, want %v", urls, tt.wantURLs)
+			}
+		})
+	}
+}
+
+func Test_mergeImageLayoutIndex(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	descriptor := func(ref string, d digest.Digest) imgspecv1.Descriptor {
+		desc := imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageManifest, Digest: d, Size: 1}
+		if ref != "" {
+			desc.Annotations = map[string]string{imgspecv1.AnnotationRefName: ref}
+		}
+		return desc
+	}
+	one, two, three := digest.FromString("one"), digest.FromString("two"), digest.FromString("three")
+
+	tests := []struct {
+		name     string
+		existing []imgspecv1.Descriptor
+		imported []imgspecv1.Descriptor
+		want     []imgspecv1.Descriptor
+	}{
+		{"1", []imgspecv1.Descriptor{descriptor("app:1.0", one)}, []imgspecv1.Descriptor{descriptor("tool:1.0", two)}, []imgspecv1.Descriptor{descriptor("app:1.0", one), descriptor("tool:1.0", two)}},
+		{"2", []imgspecv1.Descriptor{descriptor("app:1.0", one), descriptor("tool:1.0", two)}, []imgspecv1.Descriptor{descriptor("app:1.0", three)}, []imgspecv1.Descriptor{descriptor("tool:1.0", two), descriptor("app:1.0", three)}},
+		{"3", []imgspecv1.Descriptor{descriptor("", one)}, []imgspecv1.Descriptor{descriptor("", one)}, []imgspecv1.Descriptor{descriptor("", one)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			existingPath := path.Join(dir, tt.name+"-existing.json")
+			importedPath := path.Join(dir, tt.name+"-imported.json")
+			for p, manifests := range map[string][]imgspecv1.Descriptor{existingPath: tt.existing, importedPath: tt.imported} {
+				content, _ := json.Marshal(imgspecv1.Index{Manifests: manifests})
+				os.WriteFile(p, content, 0644)
+			}
+			if err := mergeImageLayoutIndex(existingPath, importedPath); err != nil {
+				t.Fatalf("mergeImageLayoutIndex() error = %v", err)
+			}
+			content, _ := os.ReadFile(existingPath)
+			var got imgspecv1.Index
+			json.Unmarshal(content, &got)
+			if !reflect.DeepEqual(got.Manifests, tt.want) {
+				t.Errorf("mergeImageLayoutIndex() = %v, want %v", got.Manifests, tt.want)
+			}
+		})
+	}
+}
+
+// writeTestBundle writes the files into a gzipped tar archive.
+func writeTestBundle(t *testing.T, archive string, files map[string][]byte) {
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatalf("creating archive: %s", err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err = writeTarFile(tw, nil, name, bytes.NewReader(files[name]), int64(len(files[name])))
+		if err != nil {
+			t.Fatalf("writing archive: %s", err)
+		}
+	}
+	tw.Close()
+	gz.Close()
+}
@@ -0,0 +1,203 @@
+package service
+
+import (
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/provenance"
+	"helm.sh/helm/v3/pkg/repo"
+	"sigs.k8s.io/yaml"
+)
+
+// IndexServiceInterface defines an Index service
+type IndexServiceInterface interface {
+	Index() error
+}
+
+// IndexService structure definition
+type IndexService struct {
+	folder       string
+	newRootURL   string
+	verbose      bool
+	ignoreErrors bool
+	logger       *log.Logger
+}
+
+// NewIndexService return a new instance of IndexService
+func NewIndexService(folder string, newRootURL string, verbose bool, ignoreErrors bool, logger *log.Logger) IndexServiceInterface {
+	return &IndexService{
+		folder:       folder,
+		newRootURL:   newRootURL,
+		verbose:      verbose,
+		ignoreErrors: ignoreErrors,
+		logger:       logger,
+	}
+}
+
+// Index rebuilds the index file of the folder from the charts in it. The
+// creation dates of the charts already in the index file are kept.
+func (i *IndexService) Index() error {
+	existing, err := loadExistingIndex(i.folder)
+	if err != nil {
+		i.logger.Printf("error: %s", err)
+		return err
+	}
+
+	index, err := i.buildIndex(existing)
+	if err != nil {
+		return err
+	}
+
+	err = writeIndexFile(i.folder, index)
+	if err != nil {
+		i.logger.Printf("error: cannot write index file: %s", err)
+		return err
+	}
+	i.logger.Printf("index: %d charts", countCharts(index))
+	return nil
+}
+
+// buildIndex returns the index of the chart archives of the folder, with
+// digests computed from the archives. The creation dates and the
+// annotations added by the mirror are taken from the first of the known
+// index files listing a chart.
+func (i *IndexService) buildIndex(known ...*repo.IndexFile) (*repo.IndexFile, error) {
+	entries, err := os.ReadDir(i.folder)
+	if err != nil {
+		i.logger.Printf("error: %s", err)
+		return nil, err
+	}
+
+	index := repo.NewIndexFile()
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") || !strings.HasSuffix(e.Name(), ".tgz") {
+			continue
+		}
+		err := i.addChart(index, e.Name(), known)
+		if err != nil {
+			if i.ignoreErrors {
+				i.logger.Printf("WARNING: indexing chart %s - %s", e.Name(), err)
+				continue
+			}
+			i.logger.Printf("error: indexing chart %s: %s", e.Name(), err)
+			return nil, err
+		}
+	}
+	index.SortEntries()
+	return index, nil
+}
+
+// addChart adds a chart archive of the folder to the index.
+func (i *IndexService) addChart(index *repo.IndexFile, chartFileName string, known []*repo.IndexFile) error {
+	chartPath := path.Join(i.folder, chartFileName)
+	c, err := loader.Load(chartPath)
+	if err != nil {
+		return err
+	}
+	if index.Has(c.Name(), c.Metadata.Version) {
+		return errors.Errorf("chart %s(%s) is in several archives", c.Name(), c.Metadata.Version)
+	}
+	digest, err := provenance.DigestFile(chartPath)
+	if err != nil {
+		return err
+	}
+
+	var previous *repo.ChartVersion
+	for _, k := range known {
+		if previous = findChart(k, c.Name(), c.Metadata.Version); previous != nil {
+			break
+		}
+	}
+	if previous == nil {
+		if i.verbose {
+			i.logger.Printf("indexing new chart %s(%s)", c.Name(), c.Metadata.Version)
+		}
+		return index.MustAdd(c.Metadata, chartFileName, i.newRootURL, digest)
+	}
+
+	if parents, ok := previous.Annotations[requiredByAnnotation]; ok {
+		if c.Metadata.Annotations == nil {
+			c.Metadata.Annotations = make(map[string]string)
+		}
+		c.Metadata.Annotations[requiredByAnnotation] = parents
+	}
+	err = index.MustAdd(c.Metadata, chartFileName, i.newRootURL, digest)
+	if err != nil {
+		return err
+	}
+	findChart(index, c.Name(), c.Metadata.Version).Created = previous.Created
+	return nil
+}
+
+// findChart returns the version of a chart listed in the index, nil if the
+// index or the version is missing.
+func findChart(index *repo.IndexFile, name string, version string) *repo.ChartVersion {
+	if index == nil {
+		return nil
+	}
+	for _, v := range index.Entries[name] {
+		if v.Version == version {
+			return v
+		}
+	}
+	return nil
+}
+
+// loadExistingIndex loads the index file of the folder, nil if there is none.
+func loadExistingIndex(folder string) (*repo.IndexFile, error) {
+	indexPath := path.Join(folder, indexFileName)
+	if !fileExists(indexPath) {
+		return nil, nil
+	}
+	index, err := repo.LoadIndexFile(indexPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot load index file %s", indexPath)
+	}
+	return index, nil
+}
+
+// publishIndex writes the index file of the destination folder. With
+// RegenerateIndex it is rebuilt from the charts of the folder, the mirrored
+// index taking precedence over the existing one for creation dates.
+func (g *GetService) publishIndex(index *repo.IndexFile) error {
+	if g.options.RegenerateIndex {
+		existing, err := loadExistingIndex(g.config.Name)
+		if err != nil {
+			return err
+		}
+		indexer := &IndexService{
+			folder:       g.config.Name,
+			newRootURL:   g.newRootURL,
+			verbose:      g.verbose,
+			ignoreErrors: g.ignoreErrors,
+			logger:       g.logger,
+		}
+		index, err = indexer.buildIndex(index, existing)
+		if err != nil {
+			return err
+		}
+	}
+	return writeIndexFile(g.config.Name, index)
+}
+
+// writeIndexFile publishes the index file of the folder.
+func writeIndexFile(folder string, index *repo.IndexFile) error {
+	content, err := yaml.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path.Join(folder, indexFileName), content)
+}
+
+// countCharts returns the number of chart versions of the index.
+func countCharts(index *repo.IndexFile) int {
+	var count int
+	for _, versions := range index.Entries {
+		count += len(versions)
+	}
+	return count
+}
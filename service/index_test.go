@@ -0,0 +1,170 @@
+package service
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/provenance"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+func TestNewIndexService(t *testing.T) {
+	want := &IndexService{folder: "/folder", newRootURL: "https://mirror.local.lan/charts", logger: fakeLogger}
+	if got := NewIndexService("/folder", "https://mirror.local.lan/charts", false, false, fakeLogger); !reflect.DeepEqual(got, want) {
+		t.Errorf("NewIndexService() = %v, want %v", got, want)
+	}
+}
+
+func TestIndexService_Index(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		newRootURL   string
+		ignoreErrors bool
+		corrupt      bool
+		wantURLs     map[string]string
+		wantErr      bool
+	}{
+		{"1", "", false, false, map[string]string{"a": "a-1.0.0.tgz", "b": "b-1.0.0.tgz"}, false},
+		{"2", "https://mirror.local.lan/charts", false, false, map[string]string{"a": "https://mirror.local.lan/charts/a-1.0.0.tgz", "b": "https://mirror.local.lan/charts/b-1.0.0.tgz"}, false},
+		{"3", "", false, true, nil, true},
+		{"4", "", true, true, map[string]string{"a": "a-1.0.0.tgz", "b": "b-1.0.0.tgz"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workDir := path.Join(dir, tt.name)
+			os.MkdirAll(path.Join(workDir, "quarantine"), 0744)
+			packageTestChart(t, workDir, "a", "1.0.0")
+			packageTestChart(t, workDir, "b", "1.0.0")
+			// the existing index lists a with a stale digest, and a chart no longer in the folder
+			existing := repo.NewIndexFile()
+			existing.MustAdd(&chart.Metadata{APIVersion: chart.APIVersionV2, Name: "a", Version: "1.0.0"}, "a-1.0.0.tgz", "", "stale")
+			existing.MustAdd(&chart.Metadata{APIVersion: chart.APIVersionV2, Name: "gone", Version: "1.0.0"}, "gone-1.0.0.tgz", "", "digest")
+			existing.Entries["a"][0].Created = created
+			existing.Entries["a"][0].Annotations = map[string]string{requiredByAnnotation: "parent-1.0.0"}
+			err := existing.WriteFile(path.Join(workDir, indexFileName), 0644)
+			if err != nil {
+				t.Fatalf("writing index: %s", err)
+			}
+			if tt.corrupt {
+				os.WriteFile(path.Join(workDir, "corrupt-1.0.0.tgz"), []byte("corrupt"), 0644)
+			}
+
+			i := &IndexService{folder: workDir, newRootURL: tt.newRootURL, ignoreErrors: tt.ignoreErrors, logger: fakeLogger}
+			if err := i.Index(); (err != nil) != tt.wantErr {
+				t.Errorf("IndexService.Index() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			index, err := repo.LoadIndexFile(path.Join(workDir, indexFileName))
+			if err != nil {
+				t.Fatalf("loading index: %s", err)
+			}
+			urls := make(map[string]string)
+			for name, versions := range index.Entries {
+				for _, v := range versions {
+					urls[name] = v.URLs[0]
+					digest, _ := provenance.DigestFile(path.Join(workDir, name+"-1.0.0.tgz"))
+					if v.Digest != digest {
+						t.Errorf("IndexService.Index() %s digest = %s, want %s", name, v.Digest, digest)
+					}
+				}
+			}
+			if !reflect.DeepEqual(urls, tt.wantURLs) {
+				t.Errorf("IndexService.Index() urls = %v, want %v", urls, tt.wantURLs)
+			}
+			a := index.Entries["a"][0]
+			if !a.Created.Equal(created) || a.Annotations[requiredByAnnotation] != "parent-1.0.0" {
+				t.Errorf("IndexService.Index() did not keep the creation date and annotations of %s: %v, %v", a.Name, a.Created, a.Annotations)
+			}
+			if index.Entries["b"][0].Created.IsZero() {
+				t.Errorf("IndexService.Index() new chart b has no creation date")
+			}
+		})
+	}
+}
+
+func TestGetService_Get_regenerateIndex(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	upstream := path.Join(dir, "upstream")
+	os.MkdirAll(upstream, 0744)
+	packageTestChart(t, upstream, "a", "1.0.0")
+	writeTestIndex(t, upstream, "http://127.0.0.1")
+
+	tests := []struct {
+		name    string
+		options GetOptions
+		want    []string
+	}{
+		{"1", GetOptions{}, []string{"a"}},
+		{"2", GetOptions{RegenerateIndex: true}, []string{"a", "local"}},
+		{"3", GetOptions{RegenerateIndex: true, Prune: true}, []string{"a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workDir := path.Join(dir, tt.name)
+			os.MkdirAll(workDir, 0744)
+			packageTestChart(t, workDir, "local", "1.0.0")
+			content, err := os.ReadFile(path.Join(upstream, "a-1.0.0.tgz"))
+			if err != nil {
+				t.Fatalf("reading chart: %s", err)
+			}
+			os.WriteFile(path.Join(workDir, "a-1.0.0.tgz"), content, 0644)
+			indexFilePath := path.Join(dir, tt.name+"-index.yaml")
+			index, _ := os.ReadFile(path.Join(upstream, indexFileName))
+			os.WriteFile(indexFilePath, index, 0644)
+
+			g := &GetService{
+				config:        repo.Entry{Name: workDir},
+				logger:        fakeLogger,
+				indexFilePath: indexFilePath,
+				options:       tt.options,
+			}
+			loaded, err := repo.LoadIndexFile(indexFilePath)
+			if err != nil {
+				t.Fatalf("loading index: %s", err)
+			}
+			mirrored := loaded.Entries["a"]
+			err = g.pruneCharts(mirrored, nil)
+			if err != nil {
+				t.Fatalf("GetService.pruneCharts() error = %v", err)
+			}
+			if err := g.prepareIndexFile(mirrored, nil, nil); err != nil {
+				t.Fatalf("GetService.prepareIndexFile() error = %v", err)
+			}
+
+			got, err := repo.LoadIndexFile(path.Join(workDir, indexFileName))
+			if err != nil {
+				t.Fatalf("loading index: %s", err)
+			}
+			var names []string
+			for _, name := range []string{"a", "local"} {
+				if got.Has(name, "1.0.0") {
+					names = append(names, name)
+				}
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("GetService.prepareIndexFile() charts = %v, want %v", names, tt.want)
+			}
+			if !got.Entries["a"][0].Created.Equal(loaded.Entries["a"][0].Created) {
+				t.Errorf("GetService.prepareIndexFile() did not keep the upstream creation date")
+			}
+		})
+	}
+}
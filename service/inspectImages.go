@@ -8,6 +8,7 @@ import (
 	"path"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -47,6 +48,20 @@ func NewImagesService(target string, verbose bool, ignoreErrors bool, formatter
 
 // Images extracts al the images in the Helm Charts downloaded by the get command
 func (i *ImagesService) Images() error {
+	err := i.process()
+	if err != nil {
+		return err
+	}
+	err = i.formatter.Output(i.buffer)
+	if err != nil {
+		i.logger.Printf("writing output: %s", err)
+		return err
+	}
+	return nil
+}
+
+// process extracts the images of the target, a chart or a folder of charts.
+func (i *ImagesService) process() error {
 	fi, err := os.Stat(i.target)
 	if err != nil {
 		i.logger.Printf("error: cannot read target: %s", i.target)
@@ -62,11 +77,6 @@ func (i *ImagesService) Images() error {
 		i.logger.Printf("error: processing target %s: %s", i.target, err)
 		return err
 	}
-	err = i.formatter.Output(i.buffer)
-	if err != nil {
-		i.logger.Printf("writing output: %s", err)
-		return err
-	}
 	return nil
 }
 
@@ -87,7 +97,9 @@ func (i *ImagesService) processDirectory(target string) error {
 				i.logger.Printf("error: cannot access a dir %q: %v\n", dir, err)
 				return err
 			}
-			if !info.IsDir() && strings.Contains(info.Name(), ".tgz") {
+			// hidden files, like the part files of interrupted downloads,
+			// are not charts
+			if !info.IsDir() && !strings.HasPrefix(info.Name(), ".") && strings.HasSuffix(info.Name(), ".tgz") {
 				hasTgzCharts = true
 				err := i.processTarget(path.Join(target, info.Name()))
 				if err != nil && i.ignoreErrors {
@@ -156,6 +168,21 @@ func (i *ImagesService) processTarget(target string) error {
 	return nil
 }
 
+// imageList returns the distinct images found in the charts processed, sorted.
+func (i *ImagesService) imageList() []string {
+	seen := make(map[string]bool)
+	var images []string
+	for _, image := range strings.Split(i.buffer.String(), "\n") {
+		if image == "" || seen[image] {
+			continue
+		}
+		seen[image] = true
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	return images
+}
+
 func sanitizeImageString(s string) string {
 	s = strings.Replace(s, "\"", "", 2)
 	s = strings.TrimSpace(s)
@@ -89,6 +89,13 @@ func TestImagesService_processDirectory(t *testing.T) {
 	defer os.RemoveAll(dir)
 	processPath := path.Join(dir, "processfolder")
 	processTgzPath := path.Join(dir, "processtgz")
+	// a mirror folder with the part file of an interrupted download
+	partPath := path.Join(dir, "partfolder")
+	os.MkdirAll(partPath, 0777)
+	os.Symlink(path.Join(processTgzPath, "chart1.tgz"), path.Join(partPath, "chart1.tgz"))
+	chart2, _ := os.ReadFile(path.Join(processTgzPath, "chart2.tgz"))
+	os.WriteFile(path.Join(partPath, partFileName("chart2.tgz")), chart2[:len(chart2)/2], 0644)
+	os.WriteFile(path.Join(partPath, partFileName("chart2.tgz")+validatorExtension), []byte(`"v1"`), 0644)
 	type fields struct {
 		target    string
 		imageFile string
@@ -102,6 +109,7 @@ func TestImagesService_processDirectory(t *testing.T) {
 		{"2", fields{processTgzPath, "images"}, true},
 		{"3", fields{path.Join(processTgzPath, "chart1.tgz"), "images"}, true},
 		{"4", fields{path.Join(processPath, "chart6.tgz"), "images"}, true},
+		{"5", fields{partPath, "images"}, false},
 	}
 	for _, tt := range tests {
 		var buf bytes.Buffer
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"log"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// Policies applied when several repositories mirrored into the same
+// destination publish the same version of a chart
+const (
+	ConflictPrefix    = "prefix"
+	ConflictFirstWins = "first-wins"
+	ConflictFail      = "fail"
+)
+
+// mergedIndex is the index file of a repository mirrored into a subfolder of
+// a merged destination, the subfolder being named after the repository
+type mergedIndex struct {
+	name  string
+	index *repo.IndexFile
+}
+
+// mergeIndexes merges the index files of the repositories of a destination,
+// in the order of the manifest. The URLs relative to a subfolder are made
+// relative to the destination. The same version of a chart published by two
+// repositories with the same digest is listed once, with different digests it
+// is a conflict handled according to the policy:
+//   - prefix: the chart is listed as <repository>-<chart> for every repository
+//     publishing it
+//   - first-wins: the version of the first repository is listed
+//   - fail: the merge fails
+func mergeIndexes(indexes []mergedIndex, policy string, logger *log.Logger) (*repo.IndexFile, error) {
+	conflicting, err := conflictingCharts(indexes, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := repo.NewIndexFile()
+	origins := make(map[*repo.ChartVersion]string)
+	for _, m := range indexes {
+		for name, versions := range m.index.Entries {
+			mergedName := name
+			if conflicting[name] {
+				mergedName = m.name + "-" + name
+			}
+			for _, v := range versions {
+				if previous := findChart(merged, mergedName, v.Version); previous != nil {
+					if previous.Digest == v.Digest {
+						continue
+					}
+					if policy != ConflictFirstWins {
+						return nil, errors.Errorf("chart %s(%s) is published by repositories %s and %s", mergedName, v.Version, origins[previous], m.name)
+					}
+					logger.Printf("WARNING: chart %s(%s) of repository %s conflicts with repository %s, skipped", name, v.Version, m.name, origins[previous])
+					continue
+				}
+
+				chart := rebaseChart(v, m.name)
+				chart.Name = mergedName
+				merged.Entries[mergedName] = append(merged.Entries[mergedName], chart)
+				origins[chart] = m.name
+			}
+		}
+	}
+	merged.SortEntries()
+	return merged, nil
+}
+
+// conflictingCharts returns the names of the charts published with the same
+// version and different digests by several repositories, to be prefixed. It
+// fails on the first conflict with the fail policy.
+func conflictingCharts(indexes []mergedIndex, policy string) (map[string]bool, error) {
+	conflicting := make(map[string]bool)
+	if policy == ConflictFirstWins {
+		return conflicting, nil
+	}
+
+	type origin struct {
+		repository string
+		digest     string
+	}
+	published := make(map[string]origin)
+	for _, m := range indexes {
+		for name, versions := range m.index.Entries {
+			for _, v := range versions {
+				key := name + "-" + v.Version
+				previous, ok := published[key]
+				if !ok {
+					published[key] = origin{m.name, v.Digest}
+					continue
+				}
+				if previous.digest == v.Digest || previous.repository == m.name {
+					continue
+				}
+				if policy != ConflictPrefix {
+					return nil, errors.Errorf("chart %s(%s) is published by repositories %s and %s", name, v.Version, previous.repository, m.name)
+				}
+				conflicting[name] = true
+			}
+		}
+	}
+	return conflicting, nil
+}
+
+// rebaseChart returns a copy of the chart whose relative URLs point into the
+// subfolder of the repository.
+func rebaseChart(chart *repo.ChartVersion, subfolder string) *repo.ChartVersion {
+	rebased := *chart
+	metadata := *chart.Metadata
+	rebased.Metadata = &metadata
+	rebased.URLs = make([]string, len(chart.URLs))
+	for i, u := range chart.URLs {
+		if strings.Contains(u, "://") || strings.HasPrefix(u, "/") {
+			rebased.URLs[i] = u
+			continue
+		}
+		rebased.URLs[i] = path.Join(subfolder, u)
+	}
+	return &rebased
+}
+
+// mergeDestination merges the index files of the repositories mirrored into
+// subfolders of the destination into the index file of the destination. The
+// repositories without an index file, never mirrored successfully, are left
+// out.
+func (s *SyncService) mergeDestination(destination string, repositories []string) error {
+	var indexes []mergedIndex
+	for _, name := range repositories {
+		index, err := loadExistingIndex(path.Join(destination, name))
+		if err != nil {
+			return err
+		}
+		if index == nil {
+			s.logger.Printf("WARNING: repository %s has no index file to merge into %s", name, destination)
+			continue
+		}
+		indexes = append(indexes, mergedIndex{name: name, index: index})
+	}
+
+	policy := s.manifest.ConflictPolicy
+	if policy == "" {
+		policy = ConflictFail
+	}
+	merged, err := mergeIndexes(indexes, policy, s.logger)
+	if err != nil {
+		return err
+	}
+	err = writeIndexFile(destination, merged)
+	if err != nil {
+		return errors.Wrap(err, "cannot write index file")
+	}
+	s.logger.Printf("merged %d repositories into %s: %d charts", len(indexes), destination, countCharts(merged))
+	return nil
+}
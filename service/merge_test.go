@@ -0,0 +1,193 @@
+package service
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+func Test_mergeIndexes(t *testing.T) {
+	newIndex := func(charts ...*repo.ChartVersion) *repo.IndexFile {
+		index := repo.NewIndexFile()
+		for _, c := range charts {
+			index.Entries[c.Name] = append(index.Entries[c.Name], c)
+		}
+		return index
+	}
+	newChart := func(name string, version string, digest string, url string) *repo.ChartVersion {
+		return &repo.ChartVersion{Metadata: &chart.Metadata{Name: name, Version: version}, Digest: digest, URLs: []string{url}}
+	}
+	one := mergedIndex{"one", newIndex(
+		newChart("a", "1.0.0", "a1", "a-1.0.0.tgz"),
+		newChart("b", "1.0.0", "b1", "b-1.0.0.tgz"),
+	)}
+	two := mergedIndex{"two", newIndex(
+		newChart("a", "1.0.0", "a2", "a-1.0.0.tgz"),
+		newChart("a", "2.0.0", "a3", "a-2.0.0.tgz"),
+		newChart("b", "1.0.0", "b1", "b-1.0.0.tgz"),
+		newChart("c", "1.0.0", "c1", "https://mirror.local.lan/charts/c-1.0.0.tgz"),
+	)}
+
+	tests := []struct {
+		name    string
+		indexes []mergedIndex
+		policy  string
+		want    map[string]map[string]string
+		wantErr bool
+	}{
+		{"1", []mergedIndex{one, two}, ConflictPrefix, map[string]map[string]string{
+			"one-a": {"1.0.0": "one/a-1.0.0.tgz"},
+			"two-a": {"1.0.0": "two/a-1.0.0.tgz", "2.0.0": "two/a-2.0.0.tgz"},
+			"b":     {"1.0.0": "one/b-1.0.0.tgz"},
+			"c":     {"1.0.0": "https://mirror.local.lan/charts/c-1.0.0.tgz"},
+		}, false},
+		{"2", []mergedIndex{one, two}, ConflictFirstWins, map[string]map[string]string{
+			"a": {"1.0.0": "one/a-1.0.0.tgz", "2.0.0": "two/a-2.0.0.tgz"},
+			"b": {"1.0.0": "one/b-1.0.0.tgz"},
+			"c": {"1.0.0": "https://mirror.local.lan/charts/c-1.0.0.tgz"},
+		}, false},
+		{"3", []mergedIndex{two, one}, ConflictFirstWins, map[string]map[string]string{
+			"a": {"1.0.0": "two/a-1.0.0.tgz", "2.0.0": "two/a-2.0.0.tgz"},
+			"b": {"1.0.0": "two/b-1.0.0.tgz"},
+			"c": {"1.0.0": "https://mirror.local.lan/charts/c-1.0.0.tgz"},
+		}, false},
+		{"4", []mergedIndex{one, two}, ConflictFail, nil, true},
+		{"5", []mergedIndex{one, {"three", newIndex(newChart("b", "1.0.0", "b1", "b-1.0.0.tgz"))}}, ConflictFail, map[string]map[string]string{
+			"a": {"1.0.0": "one/a-1.0.0.tgz"},
+			"b": {"1.0.0": "one/b-1.0.0.tgz"},
+		}, false},
+		{"6", []mergedIndex{one, two, {"three", newIndex(newChart("one-a", "1.0.0", "a4", "one-a-1.0.0.tgz"))}}, ConflictPrefix, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, err := mergeIndexes(tt.indexes, tt.policy, fakeLogger)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("mergeIndexes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got := make(map[string]map[string]string)
+			for name, versions := range merged.Entries {
+				got[name] = make(map[string]string)
+				for _, v := range versions {
+					if v.Name != name {
+						t.Errorf("mergeIndexes() chart %s listed as %s", v.Name, name)
+					}
+					got[name][v.Version] = v.URLs[0]
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeIndexes() = %v, want %v", got, tt.want)
+			}
+			if one.index.Entries["a"][0].Name != "a" || one.index.Entries["a"][0].URLs[0] != "a-1.0.0.tgz" {
+				t.Errorf("mergeIndexes() modified the index of repository one")
+			}
+		})
+	}
+}
+
+func TestSyncService_Sync_merge(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var urls []string
+	for _, name := range []string{"one", "two"} {
+		upstream := path.Join(dir, "upstream", name)
+		os.MkdirAll(upstream, 0744)
+		svr := httptest.NewServer(http.FileServer(http.Dir(upstream)))
+		defer svr.Close()
+		urls = append(urls, svr.URL)
+		// a differs between the repositories, shared is the same archive
+		c := &chart.Chart{Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "a", Version: "1.0.0", Description: name}}
+		if _, err := chartutil.Save(c, upstream); err != nil {
+			t.Fatalf("packaging chart: %s", err)
+		}
+		if name == "one" {
+			packageTestChart(t, upstream, "shared", "1.0.0")
+		} else {
+			content, _ := os.ReadFile(path.Join(dir, "upstream", "one", "shared-1.0.0.tgz"))
+			os.WriteFile(path.Join(upstream, "shared-1.0.0.tgz"), content, 0644)
+		}
+		writeTestIndex(t, upstream, svr.URL)
+	}
+
+	tests := []struct {
+		name    string
+		policy  string
+		rootURL string
+		want    []string
+		wantErr bool
+	}{
+		{"1", ConflictPrefix, "", []string{"one-a", "shared", "two-a"}, false},
+		{"2", ConflictFirstWins, "", []string{"a", "shared"}, false},
+		{"3", "", "", nil, true},
+		{"4", ConflictPrefix, "https://charts.internal/mirror", []string{"one-a", "shared", "two-a"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			destination := path.Join(dir, "mirror", tt.name)
+			manifest := &Manifest{ConflictPolicy: tt.policy, Repositories: []RepositoryManifest{
+				{Name: "one", URL: urls[0], Destination: destination, NewRootURL: tt.rootURL},
+				{Name: "two", URL: urls[1], Destination: destination + "/", NewRootURL: tt.rootURL},
+			}}
+			if err := manifest.validate(); err != nil {
+				t.Fatalf("Manifest.validate() error = %v", err)
+			}
+
+			var buf bytes.Buffer
+			s := NewSyncService(manifest, false, false, log.New(&buf, "", 0))
+			if err := s.Sync(); (err != nil) != tt.wantErr {
+				t.Errorf("SyncService.Sync() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for _, f := range []string{"one/a-1.0.0.tgz", "one/shared-1.0.0.tgz", "two/a-1.0.0.tgz", "two/shared-1.0.0.tgz", "one/" + indexFileName, "two/" + indexFileName} {
+				if !fileExists(path.Join(destination, f)) {
+					t.Errorf("SyncService.Sync() did not mirror %s", f)
+				}
+			}
+			if tt.wantErr {
+				if !strings.Contains(buf.String(), destination+": merge failed - ") {
+					t.Errorf("SyncService.Sync() report = %s, want the merge failure", buf.String())
+				}
+				return
+			}
+
+			index, err := repo.LoadIndexFile(path.Join(destination, indexFileName))
+			if err != nil {
+				t.Fatalf("loading index: %s", err)
+			}
+			var got []string
+			for name, versions := range index.Entries {
+				got = append(got, name)
+				u := versions[0].URLs[0]
+				if tt.rootURL != "" {
+					if !strings.HasPrefix(u, tt.rootURL+"/") {
+						t.Errorf("SyncService.Sync() chart %s has URL %s outside the root URL", name, u)
+					}
+					u = strings.TrimPrefix(u, tt.rootURL+"/")
+				}
+				if !fileExists(path.Join(destination, u)) {
+					t.Errorf("SyncService.Sync() chart %s has URL %s outside the destination", name, versions[0].URLs[0])
+				}
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SyncService.Sync() merged charts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/registry"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// getOCI mirrors the tags of a chart stored in an OCI registry and generates
+// the index file of the mirrored charts.
+func (g *GetService) getOCI() error {
+	err := g.loadKeyring()
+	if err != nil {
+		return err
+	}
+
+	ref := strings.TrimPrefix(g.config.URL, fmt.Sprintf("%s://", registry.OCIScheme))
+	client, cleanup, err := newRegistryClient(ref, g.config.Username, g.config.Password, g.config.InsecureSkipTLSverify)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	tags, err := client.Tags(ref)
+	if err != nil {
+		return err
+	}
+	name := path.Base(ref)
+	charts := make([]*repo.ChartVersion, 0, len(tags))
+	for _, tag := range tags {
+		charts = append(charts, &repo.ChartVersion{
+			Metadata: &chart.Metadata{Name: name, Version: tag},
+			URLs:     []string{fmt.Sprintf("%s:%s", ref, tag)},
+		})
+	}
+	charts, err = g.filterCharts(charts)
+	if err != nil {
+		return err
+	}
+
+	if g.options.DryRun {
+		// The size and digest of a chart are only known once pulled
+		return g.plan(charts, nil)
+	}
+
+	mirrored, err := g.mirrorCharts(charts, func(worker *GetService, chart *repo.ChartVersion) (chartStatus, error) {
+		return worker.mirrorOCIChart(client, chart)
+	})
+	if err != nil {
+		return err
+	}
+
+	g.logger.Printf("charts: %s", g.summary)
+
+	var requiredBy map[string][]string
+	if g.options.WithDependencies {
+		var dependencies []*repo.ChartVersion
+		dependencies, requiredBy, err = g.mirrorDependencies(charts, mirrored)
+		if err != nil {
+			return err
+		}
+		mirrored = append(mirrored, dependencies...)
+	}
+
+	err = g.pruneCharts(charts, requiredBy)
+	if err != nil {
+		return err
+	}
+	return g.writeOCIIndexFile(mirrored, requiredBy)
+}
+
+// mirrorOCIChart pulls a single chart from the registry into the destination
+// folder. With incremental the digest of the chart is read from its manifest
+// first, and the chart is only pulled when the local copy does not match.
+func (g *GetService) mirrorOCIChart(client *registry.Client, chart *repo.ChartVersion) (chartStatus, error) {
+	chartFileName := fmt.Sprintf("%s-%s.tgz", chart.Name, chart.Version)
+	chartPath := path.Join(g.config.Name, chartFileName)
+
+	if g.options.Incremental {
+		digest, err := ociChartDigest(client, chart.URLs[0])
+		if err != nil {
+			return g.chartError(chart, err)
+		}
+		if _, upToDate := g.checkLocalChart(chartPath, digest); upToDate {
+			chart.Digest = digest
+			if g.verbose {
+				g.logger.Printf("skipping chart %s(%s): already mirrored", chart.Name, chart.Version)
+			}
+			return chartSkipped, nil
+		}
+	}
+
+	if g.verbose {
+		g.logger.Printf("pulling chart %s", chart.URLs[0])
+	}
+	result, err := client.Pull(chart.URLs[0],
+		registry.PullOptWithProv(g.wantProvenance()),
+		registry.PullOptIgnoreMissingProv(!g.options.RequireSigned))
+	if err != nil {
+		return g.chartError(chart, err)
+	}
+
+	chart.Digest = strings.TrimPrefix(result.Chart.Digest, "sha256:")
+	exists, upToDate := g.checkLocalChart(chartPath, chart.Digest)
+	if upToDate {
+		if g.verbose {
+			g.logger.Printf("skipping chart %s(%s): already mirrored", chart.Name, chart.Version)
+		}
+		return chartSkipped, nil
+	}
+
+	prov := result.Prov.Data
+	if g.signatory != nil && prov != nil {
+		err = g.verifyProvenance(chartFileName, result.Chart.Data, prov)
+		if err != nil {
+			g.quarantine(chartFileName, result.Chart.Data)
+			return g.chartError(chart, err)
+		}
+	}
+
+	if prov != nil {
+		err = g.writeFile(chartPath+provenanceExtension, prov)
+		if err != nil {
+			return g.chartError(chart, err)
+		}
+	}
+	err = g.writeFile(chartPath, result.Chart.Data)
+	if err != nil {
+		return g.chartError(chart, err)
+	}
+
+	if exists {
+		return chartReplaced, nil
+	}
+	return chartDownloaded, nil
+}
+
+// ociChartDigest returns the SHA-256 of the chart layer of ref, read from its
+// manifest. Only the manifest, the configuration and the provenance file are
+// pulled, as the registry client pulls at least one layer.
+func ociChartDigest(client *registry.Client, ref string) (string, error) {
+	result, err := client.Pull(ref,
+		registry.PullOptWithChart(false),
+		registry.PullOptWithProv(true),
+		registry.PullOptIgnoreMissingProv(true))
+	if err != nil {
+		return "", err
+	}
+	var manifest imgspecv1.Manifest
+	err = json.Unmarshal(result.Manifest.Data, &manifest)
+	if err != nil {
+		return "", errors.Wrapf(err, "manifest of %s", ref)
+	}
+	for _, layer := range manifest.Layers {
+		if layer.MediaType == registry.ChartLayerMediaType || layer.MediaType == registry.LegacyChartLayerMediaType {
+			return layer.Digest.Encoded(), nil
+		}
+	}
+	return "", errors.Errorf("manifest of %s has no chart layer", ref)
+}
+
+// chartError reports the failure of a chart, only as a warning when errors are ignored.
+func (g *GetService) chartError(chart *repo.ChartVersion, err error) (chartStatus, error) {
+	if g.ignoreErrors {
+		g.logger.Printf("WARNING: processing chart %s(%s) - %s", chart.Name, chart.Version, err)
+		return chartFailed, nil
+	}
+	return chartFailed, err
+}
+
+// writeOCIIndexFile generates the index file of the charts pulled from the registry.
+func (g *GetService) writeOCIIndexFile(charts []*repo.ChartVersion, requiredBy map[string][]string) error {
+	index := repo.NewIndexFile()
+	err := g.addLocalCharts(index, charts, requiredBy)
+	if err != nil {
+		return err
+	}
+	return g.publishIndex(index)
+}
+
+// newRegistryClient returns a Helm registry client for the registry of ref.
+// Given credentials are kept in a temporary credentials file, removed by the
+// returned cleanup function, to leave the Helm and Docker configuration of
+// the user untouched. Without credentials the ones of the user are used.
+func newRegistryClient(ref string, username string, password string, insecure bool) (*registry.Client, func(), error) {
+	if username == "" && password == "" {
+		client, err := registry.NewClient(registry.ClientOptWriter(io.Discard))
+		return client, func() {}, err
+	}
+
+	dir, err := os.MkdirTemp("", "helm-mirror")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() {
+		os.RemoveAll(dir)
+	}
+
+	client, err := registry.NewClient(
+		registry.ClientOptWriter(io.Discard),
+		registry.ClientOptCredentialsFile(path.Join(dir, registry.CredentialsFileBasename)),
+	)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	host := strings.SplitN(ref, "/", 2)[0]
+	err = client.Login(host, registry.LoginOptBasicAuth(username, password), registry.LoginOptInsecure(insecure))
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return client, cleanup, nil
+}
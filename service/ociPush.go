@@ -0,0 +1,161 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/registry"
+)
+
+// PushServiceInterface defines a Push service
+type PushServiceInterface interface {
+	Push() error
+}
+
+// pusher is a push service reporting what it pushed
+type pusher interface {
+	PushServiceInterface
+	summary() *pushSummary
+}
+
+// pushSummary counts the charts of a push
+type pushSummary struct {
+	pushed  int
+	skipped int
+	failed  int
+}
+
+func (p *pushSummary) summary() *pushSummary {
+	return p
+}
+
+func (p pushSummary) String() string {
+	return fmt.Sprintf("%d pushed, %d already pushed, %d not pushed", p.pushed, p.skipped, p.failed)
+}
+
+// IsRemote returns true if the destination is not a folder but a server the
+// charts are staged for and pushed to.
+func IsRemote(destination string) bool {
+	return registry.IsOCI(destination) || IsChartMuseum(destination) || IsS3(destination)
+}
+
+// NewPushService return the push service of a remote destination
+func NewPushService(folder string, destination string, username string, password string, verbose bool, ignoreErrors bool, logger *log.Logger) PushServiceInterface {
+	return newPusher(folder, destination, username, password, verbose, ignoreErrors, logger)
+}
+
+func newPusher(folder string, destination string, username string, password string, verbose bool, ignoreErrors bool, logger *log.Logger) pusher {
+	if IsChartMuseum(destination) {
+		return NewChartMuseumPushService(folder, destination, username, password, verbose, ignoreErrors, logger).(pusher)
+	}
+	if IsS3(destination) {
+		return NewS3PushService(folder, destination, username, password, verbose, ignoreErrors, logger).(pusher)
+	}
+	return NewOCIPushService(folder, destination, username, password, verbose, ignoreErrors, logger).(pusher)
+}
+
+// OCIPushService structure definition
+type OCIPushService struct {
+	pushSummary
+	folder       string
+	target       string
+	username     string
+	password     string
+	verbose      bool
+	ignoreErrors bool
+	logger       *log.Logger
+}
+
+// NewOCIPushService return a new instance of OCIPushService
+func NewOCIPushService(folder string, target string, username string, password string, verbose bool, ignoreErrors bool, logger *log.Logger) PushServiceInterface {
+	return &OCIPushService{
+		folder:       folder,
+		target:       target,
+		username:     username,
+		password:     password,
+		verbose:      verbose,
+		ignoreErrors: ignoreErrors,
+		logger:       logger,
+	}
+}
+
+// Push uploads every chart of the folder, with its provenance file, to the
+// OCI registry as <target>/<chart name>:<chart version>. The versions already
+// in the registry are skipped.
+func (o *OCIPushService) Push() error {
+	ref := strings.TrimSuffix(strings.TrimPrefix(o.target, fmt.Sprintf("%s://", registry.OCIScheme)), "/")
+	client, cleanup, err := newRegistryClient(ref, o.username, o.password, false)
+	if err != nil {
+		o.logger.Printf("error: cannot create registry client: %s", err)
+		return err
+	}
+	defer cleanup()
+
+	charts, err := filepath.Glob(path.Join(o.folder, "*.tgz"))
+	if err != nil {
+		return err
+	}
+
+	tags := make(map[string][]string)
+	for _, chartPath := range charts {
+		err = o.pushChart(client, ref, chartPath, tags)
+		if err != nil {
+			if o.ignoreErrors {
+				o.logger.Printf("WARNING: pushing chart %s - %s", path.Base(chartPath), err)
+				o.failed++
+				continue
+			}
+			return err
+		}
+	}
+
+	o.logger.Printf("tags: %d pushed, %d skipped, %d failed", o.pushed, o.skipped, o.failed)
+	return nil
+}
+
+// pushChart uploads a chart unless its version is already a tag of the
+// repository. tags caches the tags of the repositories already queried.
+func (o *OCIPushService) pushChart(client *registry.Client, ref string, chartPath string, tags map[string][]string) error {
+	data, err := os.ReadFile(chartPath)
+	if err != nil {
+		return err
+	}
+	c, err := loader.LoadArchive(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	repository := fmt.Sprintf("%s/%s", ref, c.Metadata.Name)
+	if _, ok := tags[repository]; !ok {
+		// A repository that does not exist yet has no tags
+		tags[repository], err = client.Tags(repository)
+		if err != nil && o.verbose {
+			o.logger.Printf("cannot list tags of %s: %s", repository, err)
+		}
+	}
+	if registry.ContainsTag(tags[repository], c.Metadata.Version) {
+		if o.verbose {
+			o.logger.Printf("skipping %s:%s: already in the registry", repository, c.Metadata.Version)
+		}
+		o.skipped++
+		return nil
+	}
+
+	var options []registry.PushOption
+	if prov, err := os.ReadFile(chartPath + provenanceExtension); err == nil {
+		options = append(options, registry.PushOptProvData(prov))
+	}
+	result, err := client.Push(data, fmt.Sprintf("%s:%s", repository, c.Metadata.Version), options...)
+	if err != nil {
+		return err
+	}
+	o.logger.Printf("pushed %s (%s)", result.Ref, result.Manifest.Digest)
+	o.pushed++
+	return nil
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/registry"
+
+	"github.com/kplachkov/helm-mirror/fixtures"
+)
+
+func TestNewOCIPushService(t *testing.T) {
+	want := &OCIPushService{folder: "/folder", target: "oci://registry/charts", logger: fakeLogger}
+	if got := NewOCIPushService("/folder", "oci://registry/charts", "", "", false, false, fakeLogger); !reflect.DeepEqual(got, want) {
+		t.Errorf("NewOCIPushService() = %v, want %v", got, want)
+	}
+}
+
+func TestOCIPushService_Push(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	host, err := fixtures.StartRegistry()
+	if err != nil {
+		t.Fatalf("starting registry: %s", err)
+	}
+
+	folder := path.Join(dir, "folder")
+	os.MkdirAll(folder, 0744)
+	annotated := &chart.Chart{
+		Metadata: &chart.Metadata{
+			APIVersion:  chart.APIVersionV2,
+			Name:        "annotated",
+			Version:     "1.0.0",
+			Annotations: map[string]string{"category": "Infrastructure"},
+		},
+	}
+	annotatedPath, err := chartutil.Save(annotated, folder)
+	if err != nil {
+		t.Fatalf("packaging chart: %s", err)
+	}
+	os.WriteFile(annotatedPath+provenanceExtension, []byte("provenance"), 0666)
+	packageTestChart(t, folder, "plain", "1.0.0")
+	packageTestChart(t, folder, "plain", "1.1.0")
+
+	errorFolder := path.Join(dir, "error")
+	os.MkdirAll(errorFolder, 0744)
+	os.WriteFile(path.Join(errorFolder, "broken-1.0.0.tgz"), []byte("not a chart"), 0666)
+
+	tests := []struct {
+		name         string
+		folder       string
+		ignoreErrors bool
+		wantErr      bool
+		wantPushed   int
+		wantSkipped  int
+		wantFailed   int
+	}{
+		{"1", folder, false, false, 3, 0, 0},
+		{"2", folder, false, false, 0, 3, 0},
+		{"3", errorFolder, false, true, 0, 0, 0},
+		{"4", errorFolder, true, false, 0, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := &OCIPushService{
+				folder:       tt.folder,
+				target:       fmt.Sprintf("oci://%s/mirror/", host),
+				logger:       fakeLogger,
+				ignoreErrors: tt.ignoreErrors,
+			}
+			if err := o.Push(); (err != nil) != tt.wantErr {
+				t.Errorf("OCIPushService.Push() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if o.pushed != tt.wantPushed || o.skipped != tt.wantSkipped || o.failed != tt.wantFailed {
+				t.Errorf("OCIPushService.Push() = %d pushed, %d skipped, %d failed, want %d, %d, %d",
+					o.pushed, o.skipped, o.failed, tt.wantPushed, tt.wantSkipped, tt.wantFailed)
+			}
+		})
+	}
+
+	client, err := registry.NewClient()
+	if err != nil {
+		t.Fatalf("creating registry client: %s", err)
+	}
+	result, err := client.Pull(fmt.Sprintf("%s/mirror/annotated:1.0.0", host), registry.PullOptWithProv(true))
+	if err != nil {
+		t.Fatalf("pulling chart: %s", err)
+	}
+	if result.Chart.Meta.Annotations["category"] != "Infrastructure" {
+		t.Errorf("OCIPushService.Push() annotations = %v", result.Chart.Meta.Annotations)
+	}
+	if string(result.Prov.Data) != "provenance" {
+		t.Errorf("OCIPushService.Push() provenance = %s", result.Prov.Data)
+	}
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"helm.sh/helm/v3/pkg/registry"
+	"helm.sh/helm/v3/pkg/repo"
+
+	"github.com/kplachkov/helm-mirror/fixtures"
+)
+
+func TestGetService_Get_oci(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	host, err := fixtures.StartRegistry()
+	if err != nil {
+		t.Fatalf("starting registry: %s", err)
+	}
+	pushTestCharts(t, dir, host, "mychart", "1.0.0", "1.1.0", "2.0.0")
+
+	tests := []struct {
+		name         string
+		ref          string
+		allVersions  bool
+		chartVersion string
+		wantErr      bool
+		wantVersions []string
+	}{
+		{"1", "charts/mychart", false, "", false, []string{"2.0.0"}},
+		{"2", "charts/mychart", true, "", false, []string{"2.0.0", "1.1.0", "1.0.0"}},
+		{"3", "charts/mychart", false, "^1.0.0", false, []string{"1.1.0", "1.0.0"}},
+		{"4", "charts/mychart", false, "1.0.0", false, []string{"1.0.0"}},
+		{"5", "charts/mychart", false, "not a constraint", true, nil},
+		{"6", "charts/missing", false, "", true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workDir := path.Join(dir, "mirror", tt.name)
+			os.MkdirAll(workDir, 0744)
+			g := &GetService{
+				config:       repo.Entry{Name: workDir, URL: fmt.Sprintf("oci://%s/%s", host, tt.ref)},
+				logger:       fakeLogger,
+				allVersions:  tt.allVersions,
+				chartVersion: tt.chartVersion,
+			}
+			if err := g.Get(); (err != nil) != tt.wantErr {
+				t.Errorf("GetService.Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			index, err := repo.LoadIndexFile(path.Join(workDir, indexFileName))
+			if err != nil {
+				t.Fatalf("loading index: %s", err)
+			}
+			versions := index.Entries["mychart"]
+			if len(versions) != len(tt.wantVersions) {
+				t.Fatalf("GetService.Get() index versions = %d, want %v", len(versions), tt.wantVersions)
+			}
+			for i, v := range versions {
+				if v.Version != tt.wantVersions[i] {
+					t.Errorf("GetService.Get() index version = %s, want %s", v.Version, tt.wantVersions[i])
+				}
+				if v.URLs[0] != fmt.Sprintf("mychart-%s.tgz", v.Version) {
+					t.Errorf("GetService.Get() index url = %s", v.URLs[0])
+				}
+				if !fileExists(path.Join(workDir, v.URLs[0])) {
+					t.Errorf("GetService.Get() missing chart %s", v.URLs[0])
+				}
+			}
+		})
+	}
+}
+
+// pushTestCharts packages and pushes a chart for every version to the registry.
+func pushTestCharts(t *testing.T, dir string, host string, name string, versions ...string) {
+	client, err := registry.NewClient()
+	if err != nil {
+		t.Fatalf("creating registry client: %s", err)
+	}
+	for _, version := range versions {
+		chartPath := packageTestChart(t, dir, name, version)
+		data, err := os.ReadFile(chartPath)
+		if err != nil {
+			t.Fatalf("reading chart: %s", err)
+		}
+		_, err = client.Push(data, fmt.Sprintf("%s/charts/%s:%s", host, name, version))
+		if err != nil {
+			t.Fatalf("pushing chart: %s", err)
+		}
+	}
+}
+
+func TestGetService_Get_ociIncremental(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	host, err := fixtures.StartRegistry()
+	if err != nil {
+		t.Fatalf("starting registry: %s", err)
+	}
+	pushTestCharts(t, dir, host, "mychart", "1.0.0")
+	chart, err := os.ReadFile(path.Join(dir, "mychart-1.0.0.tgz"))
+	if err != nil {
+		t.Fatalf("reading chart: %s", err)
+	}
+	chartBlob := "/blobs/" + digest.FromBytes(chart).String()
+
+	// the requests to the registry are recorded through a proxy
+	var requests []string
+	var mu sync.Mutex
+	registryURL, _ := url.Parse("http://" + host)
+	proxy := httputil.NewSingleHostReverseProxy(registryURL)
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		proxy.ServeHTTP(w, r)
+	}))
+	defer svr.Close()
+	proxyHost := strings.Replace(svr.Listener.Addr().String(), "127.0.0.1", "localhost", 1)
+
+	workDir := path.Join(dir, "mirror")
+	os.MkdirAll(workDir, 0744)
+	tests := []struct {
+		name         string
+		wantSkipped  int
+		wantChartGet bool
+	}{
+		{"1", 0, true},
+		{"2", 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests = nil
+			g := &GetService{
+				config:  repo.Entry{Name: workDir, URL: fmt.Sprintf("oci://%s/charts/mychart", proxyHost)},
+				logger:  fakeLogger,
+				options: GetOptions{Incremental: true},
+			}
+			if err := g.Get(); err != nil {
+				t.Fatalf("GetService.Get() error = %v", err)
+			}
+			if g.summary.skipped != tt.wantSkipped {
+				t.Errorf("GetService.Get() skipped %d charts, want %d", g.summary.skipped, tt.wantSkipped)
+			}
+			var chartGet bool
+			for _, r := range requests {
+				if strings.HasPrefix(r, http.MethodGet+" ") && strings.HasSuffix(r, chartBlob) {
+					chartGet = true
+				}
+			}
+			if chartGet != tt.wantChartGet {
+				t.Errorf("GetService.Get() downloaded the chart = %v, want %v", chartGet, tt.wantChartGet)
+			}
+		})
+	}
+}
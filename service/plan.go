@@ -0,0 +1,157 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// Actions planned for the chart files of the destination folder
+const (
+	PlanAdd     = "add"
+	PlanReplace = "replace"
+	PlanSkip    = "skip"
+	PlanPrune   = "prune"
+)
+
+// Formats a plan is printed in
+const (
+	PlanText = "text"
+	PlanJSON = "json"
+)
+
+// Plan lists what mirroring the charts would do to the destination folder
+type Plan struct {
+	Files []PlannedFile `json:"files"`
+	// DownloadSize is the size of the charts to download whose size is known
+	DownloadSize int64 `json:"downloadSize"`
+}
+
+// PlannedFile is a chart file of the destination folder and the action
+// planned for it. Size is in bytes, zero when unknown.
+type PlannedFile struct {
+	Action  string `json:"action"`
+	File    string `json:"file"`
+	Chart   string `json:"chart,omitempty"`
+	Version string `json:"version,omitempty"`
+	URL     string `json:"url,omitempty"`
+	Size    int64  `json:"size,omitempty"`
+}
+
+// plan prints the plan of mirroring the charts without writing anything into
+// the destination folder. size returns the URL and the size of a chart to
+// download, it is nil when the sizes cannot be obtained.
+func (g *GetService) plan(charts []*repo.ChartVersion, size func(chart *repo.ChartVersion) (string, int64)) error {
+	if g.options.WithDependencies {
+		g.logger.Printf("WARNING: the dependencies of the charts are not planned on a dry run")
+	}
+
+	var p Plan
+	for _, chart := range charts {
+		chartFileName := fmt.Sprintf("%s-%s.tgz", chart.Name, chart.Version)
+		file := PlannedFile{Action: PlanAdd, File: chartFileName, Chart: chart.Name, Version: chart.Version}
+
+		exists, upToDate := g.checkLocalChart(path.Join(g.config.Name, chartFileName), chart.Digest)
+		switch {
+		case upToDate:
+			file.Action = PlanSkip
+		case exists:
+			file.Action = PlanReplace
+		}
+		if file.Action != PlanSkip && size != nil {
+			file.URL, file.Size = size(chart)
+			p.DownloadSize += file.Size
+		}
+		p.Files = append(p.Files, file)
+	}
+
+	if g.options.Prune || g.options.PruneDryRun {
+		files, err := g.prunableFiles(charts, nil)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			file := PlannedFile{Action: PlanPrune, File: f}
+			if info, err := os.Stat(path.Join(g.config.Name, f)); err == nil {
+				file.Size = info.Size()
+			}
+			p.Files = append(p.Files, file)
+		}
+	}
+
+	return p.write(g.out, g.options.PlanFormat)
+}
+
+// httpChartSize returns the URL of a chart of the repository and its size
+// from a HEAD request, zero when it cannot be obtained.
+func (g *GetService) httpChartSize(getter *httpGetter) func(chart *repo.ChartVersion) (string, int64) {
+	return func(chart *repo.ChartVersion) (string, int64) {
+		if len(chart.URLs) == 0 {
+			return "", 0
+		}
+		u, err := repo.ResolveReferenceURL(g.config.URL, chart.URLs[0])
+		if err != nil {
+			return chart.URLs[0], 0
+		}
+		size, err := getter.Size(u)
+		if err != nil && g.verbose {
+			g.logger.Printf("cannot get the size of chart %s(%s): %s", chart.Name, chart.Version, err)
+		}
+		if err != nil || size < 0 {
+			return u, 0
+		}
+		return u, size
+	}
+}
+
+// write prints the plan as text or JSON.
+func (p Plan) write(out io.Writer, format string) error {
+	switch format {
+	case PlanJSON:
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(p)
+	case "", PlanText:
+	default:
+		return errors.Errorf("not a valid plan format: %s", format)
+	}
+
+	counts := make(map[string]int)
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tFILE\tSIZE")
+	for _, f := range p.Files {
+		counts[f.Action]++
+		size := "-"
+		if f.Size > 0 {
+			size = formatSize(f.Size)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", f.Action, f.File, size)
+	}
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "plan: %d to add, %d to replace, %d to skip, %d to prune, %s to download\n",
+		counts[PlanAdd], counts[PlanReplace], counts[PlanSkip], counts[PlanPrune], formatSize(p.DownloadSize))
+	return err
+}
+
+// formatSize returns a size in bytes in a human readable form.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+func TestGetService_Get_dryRun(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	upstream := path.Join(dir, "upstream")
+	os.MkdirAll(upstream, 0744)
+	svr := httptest.NewServer(http.FileServer(http.Dir(upstream)))
+	defer svr.Close()
+	for _, name := range []string{"a", "b", "c"} {
+		packageTestChart(t, upstream, name, "1.0.0")
+	}
+	writeTestIndex(t, upstream, svr.URL)
+
+	tests := []struct {
+		name        string
+		format      string
+		wantActions map[string]string
+		wantOutput  string
+		wantErr     bool
+	}{
+		{"1", PlanJSON, map[string]string{"a-1.0.0.tgz": PlanSkip, "b-1.0.0.tgz": PlanReplace, "c-1.0.0.tgz": PlanAdd, "old-0.1.0.tgz": PlanPrune, "old-0.1.0.tgz.prov": PlanPrune}, "", false},
+		{"2", PlanText, nil, "plan: 1 to add, 1 to replace, 1 to skip, 2 to prune", false},
+		{"3", "yaml", nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workDir := path.Join(dir, "mirror", tt.name)
+			os.MkdirAll(workDir, 0744)
+			content, err := os.ReadFile(path.Join(upstream, "a-1.0.0.tgz"))
+			if err != nil {
+				t.Fatalf("reading chart: %s", err)
+			}
+			files := map[string][]byte{"a-1.0.0.tgz": content, "b-1.0.0.tgz": []byte("stale"), "old-0.1.0.tgz": []byte("old"), "old-0.1.0.tgz.prov": []byte("old")}
+			for f, content := range files {
+				err := os.WriteFile(path.Join(workDir, f), content, 0644)
+				if err != nil {
+					t.Fatalf("writing %s: %s", f, err)
+				}
+			}
+
+			var out bytes.Buffer
+			g := &GetService{
+				config:      repo.Entry{Name: workDir, URL: svr.URL},
+				logger:      fakeLogger,
+				allVersions: true,
+				out:         &out,
+				options:     GetOptions{Incremental: true, Prune: true, DryRun: true, PlanFormat: tt.format},
+			}
+			if err := g.Get(); (err != nil) != tt.wantErr {
+				t.Errorf("GetService.Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			entries, err := os.ReadDir(workDir)
+			if err != nil {
+				t.Fatalf("reading %s: %s", workDir, err)
+			}
+			var got, want []string
+			for _, e := range entries {
+				got = append(got, e.Name())
+			}
+			for f := range files {
+				want = append(want, f)
+			}
+			sort.Strings(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("GetService.Get() changed the destination folder to %v", got)
+			}
+
+			if !strings.Contains(out.String(), tt.wantOutput) {
+				t.Errorf("GetService.Get() plan = %s, want %s", out.String(), tt.wantOutput)
+			}
+			if tt.wantActions == nil {
+				return
+			}
+			var plan Plan
+			err = json.Unmarshal(out.Bytes(), &plan)
+			if err != nil {
+				t.Fatalf("decoding plan: %s", err)
+			}
+			actions := make(map[string]string)
+			var size int64
+			for _, f := range plan.Files {
+				actions[f.File] = f.Action
+				if f.Action == PlanAdd || f.Action == PlanReplace {
+					info, err := os.Stat(path.Join(upstream, f.File))
+					if err != nil || f.Size != info.Size() {
+						t.Errorf("GetService.Get() %s size = %d, want the upstream size", f.File, f.Size)
+					}
+					size += f.Size
+				}
+			}
+			if !reflect.DeepEqual(actions, tt.wantActions) {
+				t.Errorf("GetService.Get() actions = %v, want %v", actions, tt.wantActions)
+			}
+			if plan.DownloadSize != size {
+				t.Errorf("GetService.Get() download size = %d, want %d", plan.DownloadSize, size)
+			}
+		})
+	}
+}
+
+func Test_formatSize(t *testing.T) {
+	tests := []struct {
+		name string
+		size int64
+		want string
+	}{
+		{"1", 0, "0 B"},
+		{"2", 1023, "1023 B"},
+		{"3", 1536, "1.5 KiB"},
+		{"4", 5 * 1024 * 1024, "5.0 MiB"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatSize(tt.size); got != tt.want {
+				t.Errorf("formatSize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
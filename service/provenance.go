@@ -0,0 +1,90 @@
+package service
+
+import (
+	"os"
+	"path"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/provenance"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+const provenanceExtension = ".prov"
+
+// wantProvenance reports if the provenance files of the charts have to be fetched.
+func (g *GetService) wantProvenance() bool {
+	return g.options.Provenance || g.options.Keyring != "" || g.options.RequireSigned
+}
+
+// loadKeyring prepares the signatory used to verify the charts, if any.
+func (g *GetService) loadKeyring() error {
+	if g.options.Keyring == "" {
+		return nil
+	}
+	sig, err := provenance.NewFromKeyring(g.options.Keyring, "")
+	if err != nil {
+		g.logger.Printf("error: cannot load keyring %s: %s", g.options.Keyring, err)
+		return err
+	}
+	g.signatory = sig
+	return nil
+}
+
+// getProvenance downloads the provenance file of a chart and, when a keyring
+// is configured, verifies the chart with it. It returns nil when the chart is
+// not signed and signed charts are not required.
+func (g *GetService) getProvenance(chartRepo *repo.ChartRepository, chart *repo.ChartVersion, chartURL string, chartFileName string, content []byte) ([]byte, error) {
+	if !g.wantProvenance() {
+		return nil, nil
+	}
+
+	b, err := chartRepo.Client.Get(chartURL + provenanceExtension)
+	if err != nil {
+		if g.options.RequireSigned {
+			return nil, errors.Wrapf(err, "chart %s(%s) is not signed", chart.Name, chart.Version)
+		}
+		if g.verbose {
+			g.logger.Printf("chart %s(%s) has no provenance file: %s", chart.Name, chart.Version, err)
+		}
+		return nil, nil
+	}
+
+	if g.signatory != nil {
+		err = g.verifyProvenance(chartFileName, content, b.Bytes())
+		if err != nil {
+			return nil, errors.Wrapf(err, "verifying chart %s(%s)", chart.Name, chart.Version)
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// verifyProvenance checks the signature of the provenance file and the chart
+// digest it contains, before the chart is written to the destination folder.
+func (g *GetService) verifyProvenance(chartFileName string, content []byte, prov []byte) error {
+	dir, err := os.MkdirTemp("", "helm-mirror")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	chartPath := path.Join(dir, chartFileName)
+	err = os.WriteFile(chartPath, content, 0666)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(chartPath+provenanceExtension, prov, 0666)
+	if err != nil {
+		return err
+	}
+
+	ver, err := g.signatory.Verify(chartPath, chartPath+provenanceExtension)
+	if err != nil {
+		return err
+	}
+	if g.verbose {
+		for name := range ver.SignedBy.Identities {
+			g.logger.Printf("chart %s signed by %s", chartFileName, name)
+		}
+	}
+	return nil
+}
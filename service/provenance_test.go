@@ -0,0 +1,138 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/provenance"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+func TestGetService_Get_provenance(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	repoDir := path.Join(dir, "repo")
+	os.MkdirAll(repoDir, 0744)
+
+	signer, signerKeyring := newTestKeyring(t, dir, "signer")
+	_, otherKeyring := newTestKeyring(t, dir, "other")
+
+	signedChart := packageTestChart(t, repoDir, "signed", "1.0.0")
+	prov, err := (&provenance.Signatory{Entity: signer}).ClearSign(signedChart)
+	if err != nil {
+		t.Fatalf("signing chart: %s", err)
+	}
+	os.WriteFile(signedChart+provenanceExtension, []byte(prov), 0666)
+	packageTestChart(t, repoDir, "unsigned", "1.0.0")
+
+	svr := httptest.NewServer(http.FileServer(http.Dir(repoDir)))
+	defer svr.Close()
+	writeTestIndex(t, repoDir, svr.URL)
+
+	tests := []struct {
+		name         string
+		chartName    string
+		ignoreErrors bool
+		options      GetOptions
+		wantErr      bool
+		wantFiles    []string
+	}{
+		{"1", "signed", false, GetOptions{Keyring: signerKeyring}, false, []string{"signed-1.0.0.tgz", "signed-1.0.0.tgz.prov"}},
+		{"2", "signed", false, GetOptions{Keyring: otherKeyring}, true, nil},
+		{"3", "unsigned", false, GetOptions{Keyring: signerKeyring}, false, []string{"unsigned-1.0.0.tgz"}},
+		{"4", "unsigned", false, GetOptions{RequireSigned: true}, true, nil},
+		{"5", "", true, GetOptions{Keyring: signerKeyring, RequireSigned: true}, false, []string{"signed-1.0.0.tgz", "signed-1.0.0.tgz.prov"}},
+		{"6", "signed", false, GetOptions{Provenance: true}, false, []string{"signed-1.0.0.tgz", "signed-1.0.0.tgz.prov"}},
+		{"7", "", false, GetOptions{Keyring: path.Join(dir, "missing")}, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workDir := path.Join(dir, "mirror", tt.name)
+			os.MkdirAll(workDir, 0744)
+			g := &GetService{
+				config:       repo.Entry{Name: workDir, URL: svr.URL},
+				logger:       fakeLogger,
+				ignoreErrors: tt.ignoreErrors,
+				chartName:    tt.chartName,
+				options:      tt.options,
+			}
+			if err := g.Get(); (err != nil) != tt.wantErr {
+				t.Errorf("GetService.Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			files, _ := os.ReadDir(workDir)
+			got := []string{}
+			for _, f := range files {
+				if f.Name() != indexFileName {
+					got = append(got, f.Name())
+				}
+			}
+			if len(got) != len(tt.wantFiles) {
+				t.Fatalf("GetService.Get() files = %v, want %v", got, tt.wantFiles)
+			}
+			for i := range got {
+				if got[i] != tt.wantFiles[i] {
+					t.Errorf("GetService.Get() files = %v, want %v", got, tt.wantFiles)
+				}
+			}
+		})
+	}
+}
+
+// newTestKeyring creates a PGP entity and writes its public key to a keyring file.
+func newTestKeyring(t *testing.T, dir string, name string) (*openpgp.Entity, string) {
+	entity, err := openpgp.NewEntity(name, "", name+"@helm-mirror.test", nil)
+	if err != nil {
+		t.Fatalf("creating PGP entity: %s", err)
+	}
+	keyring := path.Join(dir, name+".gpg")
+	f, err := os.Create(keyring)
+	if err != nil {
+		t.Fatalf("creating keyring: %s", err)
+	}
+	defer f.Close()
+	err = entity.Serialize(f)
+	if err != nil {
+		t.Fatalf("writing keyring: %s", err)
+	}
+	return entity, keyring
+}
+
+// packageTestChart saves a minimal chart archive into dir.
+func packageTestChart(t *testing.T, dir string, name string, version string) string {
+	c := &chart.Chart{
+		Metadata: &chart.Metadata{
+			APIVersion: chart.APIVersionV2,
+			Name:       name,
+			Version:    version,
+		},
+	}
+	chartPath, err := chartutil.Save(c, dir)
+	if err != nil {
+		t.Fatalf("packaging chart: %s", err)
+	}
+	return chartPath
+}
+
+// writeTestIndex generates the index file of the charts in dir.
+func writeTestIndex(t *testing.T, dir string, url string) {
+	index, err := repo.IndexDirectory(dir, url)
+	if err != nil {
+		t.Fatalf("indexing charts: %s", err)
+	}
+	err = index.WriteFile(path.Join(dir, indexFileName), 0644)
+	if err != nil {
+		t.Fatalf("writing index: %s", err)
+	}
+}
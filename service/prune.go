@@ -0,0 +1,130 @@
+package service
+
+import (
+	"os"
+	"path"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// pruneCharts deletes the chart archives of the destination folder, and their
+// provenance files, that are neither among the selected charts nor the
+// dependencies required by them, so that the folder matches the upstream
+// repository. The charts that failed to be mirrored are kept, so that a
+// transient failure never deletes the copy already in the folder, and so are
+// all the versions of their dependencies and of the dependencies that could
+// not be resolved. The part files left by the failed downloads of the pruned
+// charts are deleted with them. On a dry run the files are only listed.
+func (g *GetService) pruneCharts(charts []*repo.ChartVersion, requiredBy map[string][]string) error {
+	if !g.options.Prune && !g.options.PruneDryRun {
+		return nil
+	}
+
+	files, err := g.prunableFiles(charts, requiredBy)
+	if err != nil {
+		return err
+	}
+
+	var pruned int
+	for _, f := range files {
+		if g.options.PruneDryRun {
+			g.logger.Printf("would prune %s", f)
+			pruned++
+			continue
+		}
+		err = os.Remove(path.Join(g.config.Name, f))
+		if err != nil {
+			if g.ignoreErrors {
+				g.logger.Printf("WARNING: cannot prune %s - %s", f, err)
+				continue
+			}
+			return err
+		}
+		if g.verbose {
+			g.logger.Printf("pruned %s", f)
+		}
+		pruned++
+	}
+
+	if g.options.PruneDryRun {
+		g.logger.Printf("pruned: %d files would be deleted", pruned)
+	} else {
+		g.logger.Printf("pruned: %d files deleted", pruned)
+	}
+	return nil
+}
+
+// prunableFiles returns the chart archives and provenance files of the
+// destination folder that are neither among the charts nor the dependencies,
+// and the part files of their downloads. The part files of the charts kept
+// are left to resume their downloads. Every version of a kept dependency is
+// kept, with the dependencies of its charts.
+func (g *GetService) prunableFiles(charts []*repo.ChartVersion, requiredBy map[string][]string) ([]string, error) {
+	mirrored := make(map[string]bool)
+	for _, c := range charts {
+		mirrored[chartKey(c)+".tgz"] = true
+	}
+	for key := range requiredBy {
+		mirrored[key+".tgz"] = true
+	}
+
+	entries, err := os.ReadDir(g.config.Name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	for name := range g.keptDependencies {
+		for _, f := range g.localVersions(entries, name, mirrored) {
+			mirrored[f] = true
+		}
+	}
+
+	var files []string
+	for _, e := range entries {
+		chartFileName := strings.TrimSuffix(e.Name(), provenanceExtension)
+		if part := strings.TrimSuffix(e.Name(), validatorExtension); strings.HasPrefix(part, ".") && strings.HasSuffix(part, partExtension) {
+			chartFileName = strings.TrimSuffix(strings.TrimPrefix(part, "."), partExtension)
+		}
+		if e.IsDir() || !strings.HasSuffix(chartFileName, ".tgz") || mirrored[chartFileName] {
+			continue
+		}
+		files = append(files, e.Name())
+	}
+	return files, nil
+}
+
+// localVersions returns the chart archives of the folder entries that are
+// versions of the named chart, and of the charts it depends on, unless
+// already kept.
+func (g *GetService) localVersions(entries []os.DirEntry, name string, kept map[string]bool) []string {
+	var files []string
+	seen := map[string]bool{name: true}
+	pending := []string{name}
+	for len(pending) > 0 {
+		current := pending[0]
+		pending = pending[1:]
+		for _, e := range entries {
+			if e.IsDir() || kept[e.Name()] || !strings.HasPrefix(e.Name(), current+"-") || !strings.HasSuffix(e.Name(), ".tgz") {
+				continue
+			}
+			// the prefix of a chart name is also the one of longer names
+			c, err := loader.Load(path.Join(g.config.Name, e.Name()))
+			if err != nil || c.Metadata.Name != current {
+				continue
+			}
+			files = append(files, e.Name())
+			for _, dep := range c.Metadata.Dependencies {
+				if !seen[dep.Name] && !packaged(c, dep) {
+					seen[dep.Name] = true
+					pending = append(pending, dep.Name)
+				}
+			}
+		}
+	}
+	return files
+}
@@ -0,0 +1,211 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+func TestGetService_pruneCharts(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	files := []string{"chart1-1.0.0.tgz", "chart1-1.0.0.tgz.prov", "chart1-0.9.0.tgz", "chart1-0.9.0.tgz.prov", "chart2-1.0.0.tgz", indexFileName, "README.md",
+		partFileName("chart1-1.0.0.tgz"), partFileName("chart1-1.0.0.tgz") + validatorExtension, partFileName("chart3-1.0.0.tgz"), partFileName("chart3-1.0.0.tgz") + validatorExtension}
+	all := append([]string{"quarantine"}, files...)
+	mirrored := []*repo.ChartVersion{
+		{Metadata: &chart.Metadata{Name: "chart1", Version: "1.0.0"}},
+	}
+	tests := []struct {
+		name       string
+		options    GetOptions
+		requiredBy map[string][]string
+		want       []string
+	}{
+		{"1", GetOptions{}, nil, all},
+		{"2", GetOptions{Prune: true}, nil, []string{"chart1-1.0.0.tgz", "chart1-1.0.0.tgz.prov", indexFileName, "README.md", "quarantine", partFileName("chart1-1.0.0.tgz"), partFileName("chart1-1.0.0.tgz") + validatorExtension}},
+		{"3", GetOptions{PruneDryRun: true}, nil, all},
+		{"4", GetOptions{Prune: true, PruneDryRun: true}, nil, all},
+		{"5", GetOptions{Prune: true}, map[string][]string{"chart2-1.0.0": {"chart1-1.0.0"}}, []string{"chart1-1.0.0.tgz", "chart1-1.0.0.tgz.prov", "chart2-1.0.0.tgz", indexFileName, "README.md", "quarantine", partFileName("chart1-1.0.0.tgz"), partFileName("chart1-1.0.0.tgz") + validatorExtension}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workDir := path.Join(dir, tt.name)
+			os.MkdirAll(path.Join(workDir, "quarantine"), 0744)
+			for _, f := range files {
+				err := os.WriteFile(path.Join(workDir, f), []byte("content"), 0644)
+				if err != nil {
+					t.Fatalf("writing %s: %s", f, err)
+				}
+			}
+			g := &GetService{
+				config:  repo.Entry{Name: workDir},
+				logger:  fakeLogger,
+				options: tt.options,
+			}
+			if err := g.pruneCharts(mirrored, tt.requiredBy); err != nil {
+				t.Errorf("GetService.pruneCharts() error = %v", err)
+			}
+
+			entries, err := os.ReadDir(workDir)
+			if err != nil {
+				t.Fatalf("reading %s: %s", workDir, err)
+			}
+			var got []string
+			for _, e := range entries {
+				got = append(got, e.Name())
+			}
+			want := append([]string{}, tt.want...)
+			sort.Strings(got)
+			sort.Strings(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("GetService.pruneCharts() left %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestGetService_Get_prune(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	upstream := path.Join(dir, "upstream")
+	os.MkdirAll(upstream, 0744)
+	fileServer := http.FileServer(http.Dir(upstream))
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/failing-1.0.0.tgz" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		fileServer.ServeHTTP(w, r)
+	}))
+	defer svr.Close()
+	packageTestChart(t, upstream, "chart", "1.0.0")
+	failingPath := packageTestChart(t, upstream, "failing", "1.0.0")
+	writeTestIndex(t, upstream, svr.URL)
+	failing, err := os.ReadFile(failingPath)
+	if err != nil {
+		t.Fatalf("reading chart: %s", err)
+	}
+
+	workDir := path.Join(dir, "mirror")
+	os.MkdirAll(workDir, 0744)
+	os.WriteFile(path.Join(workDir, "failing-1.0.0.tgz"), failing, 0644)
+	os.WriteFile(path.Join(workDir, "stale-1.0.0.tgz"), []byte("stale"), 0644)
+	g := &GetService{
+		config:       repo.Entry{Name: workDir, URL: svr.URL},
+		logger:       fakeLogger,
+		ignoreErrors: true,
+		allVersions:  true,
+		options:      GetOptions{Prune: true},
+	}
+	if err := g.Get(); err != nil {
+		t.Fatalf("GetService.Get() error = %v", err)
+	}
+	if g.summary.failed != 1 {
+		t.Errorf("GetService.Get() failed %d charts, want 1", g.summary.failed)
+	}
+	for f, want := range map[string]bool{"chart-1.0.0.tgz": true, "failing-1.0.0.tgz": true, "stale-1.0.0.tgz": false} {
+		if got := fileExists(path.Join(workDir, f)); got != want {
+			t.Errorf("GetService.Get() %s in the folder = %v, want %v", f, got, want)
+		}
+	}
+}
+
+func TestGetService_Get_pruneDependencies(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	upstream := path.Join(dir, "upstream")
+	dependencies := path.Join(dir, "dependencies")
+	os.MkdirAll(upstream, 0744)
+	os.MkdirAll(dependencies, 0744)
+	var failUpstream, failDependencies atomic.Bool
+	upstreamServer := http.FileServer(http.Dir(upstream))
+	upstreamSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failUpstream.Load() && r.URL.Path == "/umbrella-1.0.0.tgz" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		upstreamServer.ServeHTTP(w, r)
+	}))
+	defer upstreamSvr.Close()
+	dependenciesServer := http.FileServer(http.Dir(dependencies))
+	dependenciesSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failDependencies.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		dependenciesServer.ServeHTTP(w, r)
+	}))
+	defer dependenciesSvr.Close()
+
+	umbrella := &chart.Dependency{Name: "dep", Version: "^1.0.0", Repository: dependenciesSvr.URL}
+	packageTestChartWithDependencies(t, upstream, "umbrella", "1.0.0", nil, umbrella)
+	packageTestChart(t, upstream, "other", "1.0.0")
+	writeTestIndex(t, upstream, upstreamSvr.URL)
+	leaf := &chart.Dependency{Name: "leaf", Version: "~0.1.0", Repository: dependenciesSvr.URL}
+	packageTestChartWithDependencies(t, dependencies, "dep", "1.0.0", nil, leaf)
+	packageTestChart(t, dependencies, "leaf", "0.1.0")
+	writeTestIndex(t, dependencies, dependenciesSvr.URL)
+
+	tests := []struct {
+		name             string
+		failUpstream     bool
+		failDependencies bool
+		wantFiles        []string
+		wantMissing      []string
+	}{
+		{"1", true, false, []string{"umbrella-1.0.0.tgz", "dep-1.0.0.tgz", "dep-0.9.0.tgz", "leaf-0.1.0.tgz"}, []string{"stale-1.0.0.tgz"}},
+		{"2", false, true, []string{"umbrella-1.0.0.tgz", "dep-1.0.0.tgz", "dep-0.9.0.tgz", "leaf-0.1.0.tgz"}, []string{"stale-1.0.0.tgz"}},
+		{"3", false, false, []string{"umbrella-1.0.0.tgz", "dep-1.0.0.tgz", "leaf-0.1.0.tgz"}, []string{"dep-0.9.0.tgz", "stale-1.0.0.tgz"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// an earlier run left the charts and their dependencies
+			workDir := path.Join(dir, "mirror", tt.name)
+			os.MkdirAll(workDir, 0744)
+			packageTestChartWithDependencies(t, workDir, "umbrella", "1.0.0", nil, umbrella)
+			packageTestChartWithDependencies(t, workDir, "dep", "1.0.0", nil, leaf)
+			packageTestChart(t, workDir, "dep", "0.9.0")
+			packageTestChart(t, workDir, "leaf", "0.1.0")
+			packageTestChart(t, workDir, "stale", "1.0.0")
+			failUpstream.Store(tt.failUpstream)
+			failDependencies.Store(tt.failDependencies)
+
+			g := &GetService{
+				config:       repo.Entry{Name: workDir, URL: upstreamSvr.URL},
+				logger:       fakeLogger,
+				ignoreErrors: true,
+				options:      GetOptions{Prune: true, WithDependencies: true},
+			}
+			if err := g.Get(); err != nil {
+				t.Fatalf("GetService.Get() error = %v", err)
+			}
+			for _, f := range tt.wantFiles {
+				if !fileExists(path.Join(workDir, f)) {
+					t.Errorf("GetService.Get() pruned %s", f)
+				}
+			}
+			for _, f := range tt.wantMissing {
+				if fileExists(path.Join(workDir, f)) {
+					t.Errorf("GetService.Get() did not prune %s", f)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/pkg/errors"
+)
+
+// S3Scheme is the scheme of an S3 destination, s3://bucket/prefix
+const S3Scheme = "s3"
+
+const defaultS3Endpoint = "https://s3.amazonaws.com"
+
+// s3ChecksumMetadata is the user metadata holding the SHA-256 checksum of an
+// uploaded object, x-amz-meta-sha256
+const s3ChecksumMetadata = "sha256"
+
+// IsS3 returns true if the reference is an S3 destination.
+func IsS3(ref string) bool {
+	return strings.HasPrefix(ref, S3Scheme+"://")
+}
+
+// S3PushService structure definition
+type S3PushService struct {
+	pushSummary
+	folder       string
+	target       string
+	username     string
+	password     string
+	verbose      bool
+	ignoreErrors bool
+	logger       *log.Logger
+}
+
+// NewS3PushService return a new instance of S3PushService
+func NewS3PushService(folder string, target string, username string, password string, verbose bool, ignoreErrors bool, logger *log.Logger) PushServiceInterface {
+	return &S3PushService{
+		folder:       folder,
+		target:       target,
+		username:     username,
+		password:     password,
+		verbose:      verbose,
+		ignoreErrors: ignoreErrors,
+		logger:       logger,
+	}
+}
+
+// Push uploads every chart of the folder, with its provenance file, under the
+// prefix of the bucket, and then the index file. The objects already in the
+// bucket with the same content are skipped. The index file is not uploaded
+// when a chart failed, so that it never lists a chart missing from the bucket.
+func (s *S3PushService) Push() error {
+	bucket, prefix, err := parseS3Target(s.target)
+	if err != nil {
+		s.logger.Printf("error: %s", err)
+		return err
+	}
+	client, err := newS3Client(s.username, s.password)
+	if err != nil {
+		s.logger.Printf("error: cannot create S3 client: %s", err)
+		return err
+	}
+
+	ctx := context.Background()
+	listPrefix := prefix
+	if listPrefix != "" {
+		listPrefix += "/"
+	}
+	existing := make(map[string]minio.ObjectInfo)
+	for object := range client.ListObjects(ctx, bucket, minio.ListObjectsOptions{Prefix: listPrefix}) {
+		if object.Err != nil {
+			s.logger.Printf("error: cannot list objects of bucket %s: %s", bucket, object.Err)
+			return object.Err
+		}
+		existing[object.Key] = object
+	}
+
+	charts, err := filepath.Glob(path.Join(s.folder, "*.tgz"))
+	if err != nil {
+		return err
+	}
+	for _, chartPath := range charts {
+		files := []string{chartPath}
+		if fileExists(chartPath + provenanceExtension) {
+			// The provenance file is uploaded first, for the chart to be verifiable once present
+			files = []string{chartPath + provenanceExtension, chartPath}
+		}
+
+		uploaded := false
+		for _, f := range files {
+			var done bool
+			done, err = s.pushFile(ctx, client, bucket, prefix, f, existing)
+			if err != nil {
+				break
+			}
+			uploaded = uploaded || done
+		}
+		if err != nil {
+			if s.ignoreErrors {
+				s.logger.Printf("WARNING: pushing chart %s - %s", path.Base(chartPath), err)
+				s.failed++
+				continue
+			}
+			return err
+		}
+		if uploaded {
+			s.pushed++
+		} else {
+			s.skipped++
+		}
+	}
+
+	s.logger.Printf("uploads: %d pushed, %d skipped, %d failed", s.pushed, s.skipped, s.failed)
+	if s.failed > 0 {
+		s.logger.Printf("WARNING: index file not pushed, %d charts failed", s.failed)
+		return nil
+	}
+	indexPath := path.Join(s.folder, indexFileName)
+	if !fileExists(indexPath) {
+		return nil
+	}
+	_, err = s.pushFile(ctx, client, bucket, prefix, indexPath, nil)
+	if err != nil {
+		s.logger.Printf("error: cannot push index file: %s", err)
+	}
+	return err
+}
+
+// pushFile uploads a file of the folder unless an object with the same
+// content is already in the bucket. It returns whether the file was uploaded.
+func (s *S3PushService) pushFile(ctx context.Context, client *minio.Client, bucket string, prefix string, filePath string, existing map[string]minio.ObjectInfo) (bool, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return false, err
+	}
+	key := path.Join(prefix, path.Base(filePath))
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+	if object, ok := existing[key]; ok && sameContent(ctx, client, bucket, object, content, checksum) {
+		if s.verbose {
+			s.logger.Printf("skipping %s: already in the bucket", key)
+		}
+		return false, nil
+	}
+
+	_, err = client.PutObject(ctx, bucket, key, bytes.NewReader(content), int64(len(content)), minio.PutObjectOptions{
+		ContentType:  contentType(filePath),
+		UserMetadata: map[string]string{s3ChecksumMetadata: checksum},
+	})
+	if err != nil {
+		return false, err
+	}
+	if s.verbose {
+		s.logger.Printf("pushed s3://%s/%s", bucket, key)
+	}
+	return true, nil
+}
+
+// sameContent returns true if the object holds the content. The ETag of an
+// object uploaded at once is the MD5 of its content. The one of an object
+// uploaded in parts is not, the SHA-256 checksum stored in its metadata on
+// upload is compared instead. An object whose content cannot be verified is
+// reported different, to be uploaded again.
+func sameContent(ctx context.Context, client *minio.Client, bucket string, object minio.ObjectInfo, content []byte, checksum string) bool {
+	if object.Size != int64(len(content)) {
+		return false
+	}
+	etag := strings.Trim(object.ETag, `"`)
+	if !strings.Contains(etag, "-") {
+		sum := md5.Sum(content)
+		return etag == hex.EncodeToString(sum[:])
+	}
+
+	info, err := client.StatObject(ctx, bucket, object.Key, minio.StatObjectOptions{})
+	if err != nil {
+		return false
+	}
+	for k, v := range info.UserMetadata {
+		if strings.EqualFold(k, s3ChecksumMetadata) {
+			return v == checksum
+		}
+	}
+	return false
+}
+
+// contentType returns the content type of a file of a chart repository.
+func contentType(filePath string) string {
+	switch {
+	case strings.HasSuffix(filePath, ".tgz"):
+		return "application/gzip"
+	case strings.HasSuffix(filePath, provenanceExtension):
+		return "application/pgp-signature"
+	case strings.HasSuffix(filePath, ".yaml"):
+		return "application/x-yaml"
+	}
+	return "application/octet-stream"
+}
+
+// parseS3Target returns the bucket and the prefix of an S3 destination.
+func parseS3Target(target string) (string, string, error) {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != S3Scheme || u.Host == "" {
+		return "", "", errors.Errorf("not a valid S3 destination: `%s`", target)
+	}
+	return u.Host, strings.Trim(u.Path, "/"), nil
+}
+
+// newS3Client returns an S3 client configured from the standard environment:
+// the endpoint from AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL, the region from
+// AWS_REGION or AWS_DEFAULT_REGION and the credentials from the environment,
+// the AWS credentials file or the instance role. The username and password
+// given take precedence as access key and secret key.
+func newS3Client(username string, password string) (*minio.Client, error) {
+	endpoint := firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL")
+	if endpoint == "" {
+		endpoint = defaultS3Endpoint
+	}
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil || (endpointURL.Scheme != "http" && endpointURL.Scheme != "https") || endpointURL.Host == "" {
+		return nil, errors.Errorf("not a valid S3 endpoint: `%s`", endpoint)
+	}
+	region := firstEnv("AWS_REGION", "AWS_DEFAULT_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	creds := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.FileAWSCredentials{},
+		&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+	})
+	if username != "" || password != "" {
+		creds = credentials.NewStaticV4(username, password, "")
+	}
+	return minio.New(endpointURL.Host, &minio.Options{
+		Creds:        creds,
+		Secure:       endpointURL.Scheme == "https",
+		Region:       region,
+		BucketLookup: minio.BucketLookupAuto,
+	})
+}
+
+// firstEnv returns the value of the first environment variable set.
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
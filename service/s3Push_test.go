@@ -0,0 +1,191 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/kplachkov/helm-mirror/fixtures"
+)
+
+func TestNewS3PushService(t *testing.T) {
+	want := &S3PushService{folder: "/folder", target: "s3://bucket/charts", logger: fakeLogger}
+	if got := NewS3PushService("/folder", "s3://bucket/charts", "", "", false, false, fakeLogger); !reflect.DeepEqual(got, want) {
+		t.Errorf("NewS3PushService() = %v, want %v", got, want)
+	}
+}
+
+func Test_parseS3Target(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string
+		wantBucket string
+		wantPrefix string
+		wantErr    bool
+	}{
+		{"1", "s3://bucket", "bucket", "", false},
+		{"2", "s3://bucket/charts/stable/", "bucket", "charts/stable", false},
+		{"3", "s3:///charts", "", "", true},
+		{"4", "cm://bucket/charts", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucket, prefix, err := parseS3Target(tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseS3Target() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if bucket != tt.wantBucket || prefix != tt.wantPrefix {
+				t.Errorf("parseS3Target() = %v, %v, want %v, %v", bucket, prefix, tt.wantBucket, tt.wantPrefix)
+			}
+		})
+	}
+}
+
+func TestS3PushService_Push(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	s3 := fixtures.StartS3("mirror")
+	defer s3.Close()
+	t.Setenv("AWS_ENDPOINT_URL", s3.URL)
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "access")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	folder := path.Join(dir, "folder")
+	os.MkdirAll(folder, 0744)
+	signedPath := packageTestChart(t, folder, "signed", "1.0.0")
+	os.WriteFile(signedPath+provenanceExtension, []byte("provenance"), 0644)
+	packageTestChart(t, folder, "plain", "1.0.0")
+	writeTestIndex(t, folder, "")
+	s3.Put("mirror", "other/plain-1.0.0.tgz", []byte("another prefix"))
+
+	tests := []struct {
+		name         string
+		target       string
+		ignoreErrors bool
+		stale        bool
+		wantErr      bool
+		want         pushSummary
+	}{
+		{"1", "s3://mirror/charts", false, false, false, pushSummary{pushed: 2}},
+		{"2", "s3://mirror/charts/", false, false, false, pushSummary{skipped: 2}},
+		{"3", "s3://mirror/charts", false, true, false, pushSummary{pushed: 1, skipped: 1}},
+		{"4", "s3://missing/charts", false, false, true, pushSummary{}},
+		{"5", "s3://mirror", false, false, false, pushSummary{pushed: 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.stale {
+				s3.Put("mirror", "charts/plain-1.0.0.tgz", []byte("stale"))
+			}
+			s := NewS3PushService(folder, tt.target, "", "", false, tt.ignoreErrors, fakeLogger).(*S3PushService)
+			if err := s.Push(); (err != nil) != tt.wantErr {
+				t.Errorf("S3PushService.Push() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if s.pushSummary != tt.want {
+				t.Errorf("S3PushService.Push() = %s, want %s", s.pushSummary, tt.want)
+			}
+		})
+	}
+
+	want := []string{
+		"charts/" + indexFileName, "charts/plain-1.0.0.tgz", "charts/signed-1.0.0.tgz", "charts/signed-1.0.0.tgz.prov",
+		indexFileName, "other/plain-1.0.0.tgz", "plain-1.0.0.tgz", "signed-1.0.0.tgz", "signed-1.0.0.tgz.prov",
+	}
+	if got := s3.Keys("mirror"); !reflect.DeepEqual(got, want) {
+		t.Errorf("S3PushService.Push() objects = %v, want %v", got, want)
+	}
+	for _, f := range []string{"plain-1.0.0.tgz", "signed-1.0.0.tgz.prov", indexFileName} {
+		content, _ := os.ReadFile(path.Join(folder, f))
+		if !reflect.DeepEqual(s3.Object("mirror", "charts/"+f), content) {
+			t.Errorf("S3PushService.Push() object charts/%s differs from the file", f)
+		}
+		sum := sha256.Sum256(content)
+		if got := s3.Metadata("mirror", "charts/"+f)[s3ChecksumMetadata]; got != hex.EncodeToString(sum[:]) {
+			t.Errorf("S3PushService.Push() checksum of charts/%s = %s", f, got)
+		}
+	}
+}
+
+func TestS3PushService_Push_multipart(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	s3 := fixtures.StartS3("mirror")
+	defer s3.Close()
+	t.Setenv("AWS_ENDPOINT_URL", s3.URL)
+	t.Setenv("AWS_REGION", "us-east-1")
+
+	chartPath := packageTestChart(t, dir, "chart", "1.0.0")
+	content, err := os.ReadFile(chartPath)
+	if err != nil {
+		t.Fatalf("reading chart: %s", err)
+	}
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+	changed := bytes.Repeat([]byte("x"), len(content))
+
+	// the objects uploaded in parts are compared with their checksum
+	tests := []struct {
+		name     string
+		data     []byte
+		metadata map[string]string
+		want     pushSummary
+	}{
+		{"1", content, map[string]string{s3ChecksumMetadata: checksum}, pushSummary{skipped: 1}},
+		{"2", changed, map[string]string{s3ChecksumMetadata: hex.EncodeToString(make([]byte, 32))}, pushSummary{pushed: 1}},
+		{"3", changed, nil, pushSummary{pushed: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s3.PutMultipart("mirror", "charts/chart-1.0.0.tgz", tt.data, tt.metadata)
+			s := NewS3PushService(dir, "s3://mirror/charts", "access", "secret", false, false, fakeLogger).(*S3PushService)
+			if err := s.Push(); err != nil {
+				t.Fatalf("S3PushService.Push() error = %v", err)
+			}
+			if s.pushSummary != tt.want {
+				t.Errorf("S3PushService.Push() = %s, want %s", s.pushSummary, tt.want)
+			}
+			if !bytes.Equal(s3.Object("mirror", "charts/chart-1.0.0.tgz"), content) {
+				t.Errorf("S3PushService.Push() object differs from the chart")
+			}
+		})
+	}
+}
+
+func TestS3PushService_Push_failed(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	s3 := fixtures.StartS3("mirror")
+	defer s3.Close()
+	t.Setenv("AWS_ENDPOINT_URL", s3.URL)
+	t.Setenv("AWS_REGION", "us-east-1")
+
+	packageTestChart(t, dir, "plain", "1.0.0")
+	writeTestIndex(t, dir, "")
+	// an unreadable chart fails, and the index file listing it is not pushed
+	os.Mkdir(path.Join(dir, "broken-1.0.0.tgz"), 0744)
+
+	s := NewS3PushService(dir, "s3://mirror/charts", "access", "secret", false, true, fakeLogger).(*S3PushService)
+	if err := s.Push(); err != nil {
+		t.Errorf("S3PushService.Push() error = %v", err)
+	}
+	if want := (pushSummary{pushed: 1, failed: 1}); s.pushSummary != want {
+		t.Errorf("S3PushService.Push() = %s, want %s", s.pushSummary, want)
+	}
+	if got := s3.Keys("mirror"); !reflect.DeepEqual(got, []string{"charts/plain-1.0.0.tgz"}) {
+		t.Errorf("S3PushService.Push() objects = %v", got)
+	}
+}
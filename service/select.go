@@ -0,0 +1,353 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// Version lines the KeepVersions option applies to
+const (
+	KeepPerChart = "chart"
+	KeepPerMajor = "major"
+	KeepPerMinor = "minor"
+)
+
+// regexPatternPrefix marks the name patterns that are regular expressions
+const regexPatternPrefix = "regex:"
+
+// namePattern matches chart names against a glob or, with the regex: prefix,
+// a regular expression. Both have to match the whole name.
+type namePattern struct {
+	glob  string
+	regex *regexp.Regexp
+}
+
+// newNamePatterns parses the patterns matched against the chart names.
+func newNamePatterns(patterns []string) ([]*namePattern, error) {
+	parsed := make([]*namePattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, regexPatternPrefix) {
+			regex, err := regexp.Compile("^(?:" + strings.TrimPrefix(pattern, regexPatternPrefix) + ")$")
+			if err != nil {
+				return nil, errors.Wrapf(err, "not a valid regular expression: %s", pattern)
+			}
+			parsed = append(parsed, &namePattern{regex: regex})
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.Wrapf(err, "not a valid glob: %s", pattern)
+		}
+		parsed = append(parsed, &namePattern{glob: pattern})
+	}
+	return parsed, nil
+}
+
+// matches reports whether the pattern matches the whole name.
+func (p *namePattern) matches(name string) bool {
+	if p.regex != nil {
+		return p.regex.MatchString(name)
+	}
+	matched, _ := path.Match(p.glob, name)
+	return matched
+}
+
+// matchesAny reports whether any of the patterns matches the name.
+func matchesAny(patterns []*namePattern, name string) bool {
+	for _, p := range patterns {
+		if p.matches(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadPatterns reads the chart name patterns of a file, one per line. Empty
+// lines and lines starting with # are ignored.
+func ReadPatterns(patternsFile string) ([]string, error) {
+	file, err := os.Open(patternsFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(patterns) == 0 {
+		return nil, errors.Errorf("no patterns in %s", patternsFile)
+	}
+	return patterns, nil
+}
+
+// versionFilter matches chart versions against a version or a semver constraint
+type versionFilter struct {
+	version            string
+	constraint         *semver.Constraints
+	err                error
+	includePrereleases bool
+}
+
+// newVersionFilter returns a filter for the version, nil if version is empty.
+// A version that is not a valid constraint only matches itself, the error of
+// the constraint being kept for when no chart has the version.
+func newVersionFilter(version string, includePrereleases bool) *versionFilter {
+	if version == "" {
+		return nil
+	}
+	constraint, err := semver.NewConstraint(version)
+	if err != nil {
+		err = errors.Wrapf(err, "not a valid version constraint: %s", version)
+	}
+	return &versionFilter{version: version, constraint: constraint, err: err, includePrereleases: includePrereleases}
+}
+
+// check returns the error of the constraint of the filter when none of the
+// charts named name, of any name when empty, has exactly its version.
+func (f *versionFilter) check(charts []*repo.ChartVersion, name string) error {
+	if f == nil || f.err == nil {
+		return nil
+	}
+	for _, c := range charts {
+		if (name == "" || c.Name == name) && c.Version == f.version {
+			return nil
+		}
+	}
+	return f.err
+}
+
+// matches reports whether the version is the one of the filter or satisfies
+// its constraint. When pre-releases are included they are matched by their
+// release version, so 1.2.0-rc.1 satisfies ~1.2.0. A nil filter matches every
+// version.
+func (f *versionFilter) matches(version string) bool {
+	if f == nil || version == f.version {
+		return true
+	}
+	if f.constraint == nil {
+		return false
+	}
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return false
+	}
+	if f.constraint.Check(v) {
+		return true
+	}
+	if f.includePrereleases && v.Prerelease() != "" {
+		release, err := v.SetPrerelease("")
+		return err == nil && f.constraint.Check(&release)
+	}
+	return false
+}
+
+// ParseTime parses an absolute date, as RFC 3339 or YYYY-MM-DD in UTC, or a
+// duration before now such as 90d, 2w or 36h.
+func ParseTime(value string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for unit, length := range units {
+		if n, err := strconv.Atoi(strings.TrimSuffix(value, unit)); err == nil && strings.HasSuffix(value, unit) && n >= 0 {
+			return now.Add(-time.Duration(n) * length), nil
+		}
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return time.Time{}, errors.Errorf("not a valid date or duration: %s", value)
+	}
+	return now.Add(-d), nil
+}
+
+// ParseUntil parses the end of a date window like ParseTime, except that a
+// YYYY-MM-DD date stands for the whole day, up to its last instant in UTC.
+func ParseUntil(value string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+	}
+	return ParseTime(value, now)
+}
+
+// inWindow reports whether the chart was created within the since and until
+// options, the charts without a creation date are outside of any window.
+func (g *GetService) inWindow(chart *repo.ChartVersion) bool {
+	if g.options.Since.IsZero() && g.options.Until.IsZero() {
+		return true
+	}
+	if chart.Created.IsZero() {
+		return false
+	}
+	if !g.options.Since.IsZero() && chart.Created.Before(g.options.Since) {
+		return false
+	}
+	return g.options.Until.IsZero() || !chart.Created.After(g.options.Until)
+}
+
+// isPrerelease reports whether the version is a semver pre-release.
+func isPrerelease(version string) bool {
+	v, err := semver.NewVersion(version)
+	return err == nil && v.Prerelease() != ""
+}
+
+// filterCharts returns the charts to mirror out of the charts of a repository,
+// sorted by name and from the newest to the oldest version. When versions are
+// kept only the newest ones of every version line are mirrored. Otherwise
+// every version matching a version constraint is mirrored, or only the latest
+// version of a chart unless all the versions are requested.
+func (g *GetService) filterCharts(charts []*repo.ChartVersion) ([]*repo.ChartVersion, error) {
+	switch g.options.KeepVersionsPer {
+	case "", KeepPerChart, KeepPerMajor, KeepPerMinor:
+	default:
+		err := errors.Errorf("not a valid version line to keep versions per: %s", g.options.KeepVersionsPer)
+		g.logger.Printf("error: %s", err)
+		return nil, err
+	}
+	if !g.options.Since.IsZero() && !g.options.Until.IsZero() && g.options.Since.After(g.options.Until) {
+		err := errors.Errorf("since %s is after until %s", g.options.Since.Format(time.RFC3339), g.options.Until.Format(time.RFC3339))
+		g.logger.Printf("error: %s", err)
+		return nil, err
+	}
+
+	// The chart version may be an exact version that is not a constraint
+	chartVersion := newVersionFilter(g.chartVersion, g.options.IncludePrereleases)
+	err := chartVersion.check(charts, g.chartName)
+	if err != nil {
+		g.logger.Printf("error: %s", err)
+		return nil, err
+	}
+	global := newVersionFilter(g.options.VersionConstraint, g.options.IncludePrereleases)
+	if global != nil && global.err != nil {
+		g.logger.Printf("error: %s", global.err)
+		return nil, global.err
+	}
+	include, err := newNamePatterns(g.options.Include)
+	if err != nil {
+		g.logger.Printf("error: %s", err)
+		return nil, err
+	}
+	exclude, err := newNamePatterns(g.options.Exclude)
+	if err != nil {
+		g.logger.Printf("error: %s", err)
+		return nil, err
+	}
+	selections := make([]*versionFilter, len(g.options.Charts))
+	for i, selection := range g.options.Charts {
+		selections[i] = newVersionFilter(selection.Version, g.options.IncludePrereleases)
+		err = selections[i].check(charts, selection.Name)
+		if err != nil {
+			g.logger.Printf("error: chart %s: %s", selection.Name, err)
+			return nil, err
+		}
+	}
+
+	var selected []*repo.ChartVersion
+	kept := make(map[string]int)
+	for _, chart := range charts {
+		if g.chartName != "" && chart.Name != g.chartName {
+			continue
+		}
+		if len(include) > 0 && !matchesAny(include, chart.Name) {
+			continue
+		}
+		if matchesAny(exclude, chart.Name) {
+			continue
+		}
+		if g.options.ExcludePrereleases && isPrerelease(chart.Version) {
+			continue
+		}
+		if !chartVersion.matches(chart.Version) || !global.matches(chart.Version) {
+			continue
+		}
+		if !g.inWindow(chart) {
+			continue
+		}
+
+		pinned := chartVersion != nil || global != nil
+		if len(g.options.Charts) > 0 {
+			match := -1
+			for i, selection := range g.options.Charts {
+				if selection.Name == chart.Name && selections[i].matches(chart.Version) {
+					match = i
+					break
+				}
+			}
+			if match < 0 {
+				continue
+			}
+			pinned = pinned || selections[match] != nil
+		}
+
+		if g.options.KeepVersions > 0 {
+			line := versionLine(chart, g.options.KeepVersionsPer)
+			if kept[line] >= g.options.KeepVersions {
+				continue
+			}
+			kept[line]++
+			selected = append(selected, chart)
+			continue
+		}
+
+		last := len(selected) - 1
+		if !pinned && !g.allVersions && last >= 0 && selected[last].Name == chart.Name {
+			continue
+		}
+		selected = append(selected, chart)
+	}
+	return selected, nil
+}
+
+// versionLine returns the line a chart version belongs to when keeping the
+// newest versions: the chart itself, or its major or minor version line.
+func versionLine(chart *repo.ChartVersion, per string) string {
+	v, err := semver.NewVersion(chart.Version)
+	if err != nil {
+		return chart.Name
+	}
+	switch per {
+	case KeepPerMajor:
+		return fmt.Sprintf("%s@%d", chart.Name, v.Major())
+	case KeepPerMinor:
+		return fmt.Sprintf("%s@%d.%d", chart.Name, v.Major(), v.Minor())
+	}
+	return chart.Name
+}
+
+// sortedCharts returns the charts of the index file by name and from the
+// newest to the oldest version.
+func sortedCharts(index *repo.IndexFile) []*repo.ChartVersion {
+	names := make([]string, 0, len(index.Entries))
+	for name := range index.Entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var charts []*repo.ChartVersion
+	for _, name := range names {
+		charts = append(charts, index.Entries[name]...)
+	}
+	return charts
+}
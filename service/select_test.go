@@ -0,0 +1,310 @@
+package service
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+func Test_versionFilter_matches(t *testing.T) {
+	tests := []struct {
+		name               string
+		version            string
+		includePrereleases bool
+		chartVersion       string
+		want               bool
+	}{
+		{"1", "", false, "1.0.0", true},
+		{"2", "1.0.0", false, "1.0.0", true},
+		{"3", "1.0.0", false, "1.0.1", false},
+		{"4", "1.x", false, "1.9.3", true},
+		{"5", "1.x", false, "2.0.0", false},
+		{"6", ">=2.3 <3", false, "2.3.0", true},
+		{"7", ">=2.3 <3", false, "3.0.0", false},
+		{"8", "~1.2.0", false, "1.2.5-rc.1", false},
+		{"9", "~1.2.0", true, "1.2.5-rc.1", true},
+		{"10", "~1.2.0", true, "1.3.0-rc.1", false},
+		{"11", "^1.0.0-0", false, "1.2.5-rc.1", true},
+		{"12", "1.x", false, "not-semver", false},
+		{"13", "1.x", false, "v1.2.0", true},
+		{"14", "2023_05", false, "2023_05", true},
+		{"15", "2023_05", false, "2023.5.0", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newVersionFilter(tt.version, tt.includePrereleases)
+			if got := f.matches(tt.chartVersion); got != tt.want {
+				t.Errorf("versionFilter.matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_versionFilter_check(t *testing.T) {
+	charts := []*repo.ChartVersion{
+		{Metadata: &chart.Metadata{Name: "chart1", Version: "2023_05"}},
+		{Metadata: &chart.Metadata{Name: "chart2", Version: "1.0.0"}},
+	}
+	tests := []struct {
+		name      string
+		version   string
+		chartName string
+		wantErr   bool
+	}{
+		{"1", "", "", false},
+		{"2", "^1.0.0", "chart1", false},
+		{"3", "2023_05", "", false},
+		{"4", "2023_05", "chart1", false},
+		{"5", "2023_05", "chart2", true},
+		{"6", "not a constraint", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newVersionFilter(tt.version, false)
+			if err := f.check(charts, tt.chartName); (err != nil) != tt.wantErr {
+				t.Errorf("versionFilter.check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGetService_filterCharts(t *testing.T) {
+	newChart := func(name string, version string) *repo.ChartVersion {
+		return &repo.ChartVersion{Metadata: &chart.Metadata{Name: name, Version: version}}
+	}
+	charts := []*repo.ChartVersion{
+		newChart("chart1", "2.0.0-rc.1"),
+		newChart("chart1", "1.2.1"),
+		newChart("chart1", "1.2.0"),
+		newChart("chart1", "1.1.0"),
+		newChart("chart2", "0.2.0"),
+		newChart("chart2", "0.1.0"),
+	}
+	tests := []struct {
+		name         string
+		allVersions  bool
+		chartName    string
+		chartVersion string
+		options      GetOptions
+		want         []string
+		wantErr      bool
+	}{
+		{"1", false, "", "", GetOptions{}, []string{"chart1-2.0.0-rc.1", "chart2-0.2.0"}, false},
+		{"2", true, "", "", GetOptions{}, []string{"chart1-2.0.0-rc.1", "chart1-1.2.1", "chart1-1.2.0", "chart1-1.1.0", "chart2-0.2.0", "chart2-0.1.0"}, false},
+		{"3", false, "", "", GetOptions{ExcludePrereleases: true}, []string{"chart1-1.2.1", "chart2-0.2.0"}, false},
+		{"4", false, "chart1", "~1.2", GetOptions{}, []string{"chart1-1.2.1", "chart1-1.2.0"}, false},
+		{"5", false, "chart1", "1.1.0", GetOptions{}, []string{"chart1-1.1.0"}, false},
+		{"6", false, "", "", GetOptions{VersionConstraint: ">=0.2 <2"}, []string{"chart1-1.2.1", "chart1-1.2.0", "chart1-1.1.0", "chart2-0.2.0"}, false},
+		{"7", false, "", "", GetOptions{VersionConstraint: ">=2", IncludePrereleases: true}, []string{"chart1-2.0.0-rc.1"}, false},
+		{"8", false, "", "", GetOptions{Charts: []ChartSelection{{Name: "chart1", Version: "1.1 - 1.2.0"}, {Name: "chart2"}}}, []string{"chart1-1.2.0", "chart1-1.1.0", "chart2-0.2.0"}, false},
+		{"9", false, "", "", GetOptions{Charts: []ChartSelection{{Name: "chart2"}}, VersionConstraint: "<0.2"}, []string{"chart2-0.1.0"}, false},
+		{"10", false, "chart1", "not a constraint", GetOptions{}, nil, true},
+		{"11", false, "", "", GetOptions{VersionConstraint: "not a constraint"}, nil, true},
+		{"12", false, "", "", GetOptions{Charts: []ChartSelection{{Name: "chart1", Version: "not a constraint"}}}, nil, true},
+		{"13", false, "chart", "", GetOptions{}, nil, false},
+		{"14", false, "", "", GetOptions{Include: []string{"chart2"}}, []string{"chart2-0.2.0"}, false},
+		{"15", true, "", "", GetOptions{Include: []string{"chart*"}, Exclude: []string{"regex:chart[0-1]"}}, []string{"chart2-0.2.0", "chart2-0.1.0"}, false},
+		{"16", false, "", "", GetOptions{Include: []string{"regex:chart"}}, nil, false},
+		{"17", false, "", "", GetOptions{Include: []string{"[chart"}}, nil, true},
+		{"18", false, "", "", GetOptions{Exclude: []string{"regex:(chart"}}, nil, true},
+		{"19", false, "", "", GetOptions{KeepVersions: 2}, []string{"chart1-2.0.0-rc.1", "chart1-1.2.1", "chart2-0.2.0", "chart2-0.1.0"}, false},
+		{"20", false, "", "", GetOptions{KeepVersions: 1, KeepVersionsPer: KeepPerMinor}, []string{"chart1-2.0.0-rc.1", "chart1-1.2.1", "chart1-1.1.0", "chart2-0.2.0", "chart2-0.1.0"}, false},
+		{"21", false, "", "", GetOptions{KeepVersions: 1, KeepVersionsPer: KeepPerMajor, ExcludePrereleases: true}, []string{"chart1-1.2.1", "chart2-0.2.0"}, false},
+		{"22", true, "", "", GetOptions{KeepVersions: 2, KeepVersionsPer: KeepPerChart, VersionConstraint: "<2 >=0.2"}, []string{"chart1-1.2.1", "chart1-1.2.0", "chart2-0.2.0"}, false},
+		{"23", false, "", "", GetOptions{KeepVersions: 2, KeepVersionsPer: "patch"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &GetService{
+				logger:       fakeLogger,
+				allVersions:  tt.allVersions,
+				chartName:    tt.chartName,
+				chartVersion: tt.chartVersion,
+				options:      tt.options,
+			}
+			selected, err := g.filterCharts(charts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetService.filterCharts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, c := range selected {
+				got = append(got, c.Name+"-"+c.Version)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetService.filterCharts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_namePattern_matches(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		chart   string
+		want    bool
+	}{
+		{"1", "nginx", "nginx", true},
+		{"2", "nginx", "nginx-ingress", false},
+		{"3", "nginx*", "nginx-ingress", true},
+		{"4", "*-operator", "prometheus-operator", true},
+		{"5", "redi?", "redis", true},
+		{"6", "regex:nginx", "nginx-ingress", false},
+		{"7", "regex:nginx(-ingress)?", "nginx-ingress", true},
+		{"8", "regex:kube-.*|cert-manager", "cert-manager", true},
+		{"9", "regex:kube-.*|cert-manager", "my-cert-manager", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patterns, err := newNamePatterns([]string{tt.pattern})
+			if err != nil {
+				t.Fatalf("newNamePatterns() error = %v", err)
+			}
+			if got := patterns[0].matches(tt.chart); got != tt.want {
+				t.Errorf("namePattern.matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadPatterns(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+		wantErr bool
+	}{
+		{"1", "nginx\n\n# monitoring\n  prometheus-*  \nregex:kube-.*\n", []string{"nginx", "prometheus-*", "regex:kube-.*"}, false},
+		{"2", "# nothing\n\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patternsFile := path.Join(dir, tt.name)
+			err := os.WriteFile(patternsFile, []byte(tt.content), 0644)
+			if err != nil {
+				t.Fatalf("writing patterns: %s", err)
+			}
+			got, err := ReadPatterns(patternsFile)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ReadPatterns() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadPatterns() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ReadPatterns(path.Join(dir, "missing")); err == nil {
+		t.Errorf("ReadPatterns() expected an error for a missing file")
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2023, 6, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"1", "2023-01-31", time.Date(2023, 1, 31, 0, 0, 0, 0, time.UTC), false},
+		{"2", "2023-01-31T10:30:00Z", time.Date(2023, 1, 31, 10, 30, 0, 0, time.UTC), false},
+		{"3", "90d", now.Add(-90 * 24 * time.Hour), false},
+		{"4", "2w", now.Add(-14 * 24 * time.Hour), false},
+		{"5", "36h", now.Add(-36 * time.Hour), false},
+		{"6", "1h30m", now.Add(-90 * time.Minute), false},
+		{"7", "yesterday", time.Time{}, true},
+		{"8", "-5d", time.Time{}, true},
+		{"9", "-5h", time.Time{}, true},
+		{"10", "2023-13-01", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTime(tt.value, now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseTime() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("ParseTime() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseUntil(t *testing.T) {
+	now := time.Date(2023, 6, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"1", "2023-12-31", time.Date(2023, 12, 31, 23, 59, 59, 999999999, time.UTC), false},
+		{"2", "2023-01-31T10:30:00Z", time.Date(2023, 1, 31, 10, 30, 0, 0, time.UTC), false},
+		{"3", "90d", now.Add(-90 * 24 * time.Hour), false},
+		{"4", "yesterday", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseUntil(tt.value, now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseUntil() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("ParseUntil() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetService_inWindow(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2023, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+	endOfDay := func(d int) time.Time {
+		until, _ := ParseUntil(day(d).Format("2006-01-02"), time.Now())
+		return until
+	}
+	tests := []struct {
+		name    string
+		since   time.Time
+		until   time.Time
+		created time.Time
+		want    bool
+	}{
+		{"1", time.Time{}, time.Time{}, time.Time{}, true},
+		{"2", day(10), time.Time{}, day(10), true},
+		{"3", day(10), time.Time{}, day(9), false},
+		{"4", time.Time{}, day(10), day(10), true},
+		{"5", time.Time{}, day(10), day(11), false},
+		{"6", day(5), day(10), day(7), true},
+		{"7", day(5), day(10), time.Time{}, false},
+		{"8", time.Time{}, endOfDay(10), day(10).Add(18 * time.Hour), true},
+		{"9", time.Time{}, endOfDay(10), day(11), false},
+		{"10", day(10), endOfDay(10), day(10).Add(18 * time.Hour), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &GetService{options: GetOptions{Since: tt.since, Until: tt.until}}
+			c := &repo.ChartVersion{Metadata: &chart.Metadata{Name: "chart", Version: "1.0.0"}, Created: tt.created}
+			if got := g.inWindow(c); got != tt.want {
+				t.Errorf("GetService.inWindow() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	g := &GetService{logger: fakeLogger, options: GetOptions{Since: day(10), Until: day(5)}}
+	if _, err := g.filterCharts(nil); err == nil {
+		t.Errorf("GetService.filterCharts() expected an error when since is after until")
+	}
+}
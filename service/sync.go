@@ -0,0 +1,435 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/registry"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// SyncServiceInterface defines a Sync service
+type SyncServiceInterface interface {
+	Sync() error
+}
+
+// Manifest lists the repositories mirrored by a SyncService. The repositories
+// sharing a destination folder are mirrored into subfolders named after them
+// and merged into the index file of the destination, the conflicts between
+// them handled according to ConflictPolicy. The NewRootURL of such a
+// repository is the one of the destination, the subfolder being appended.
+type Manifest struct {
+	Repositories   []RepositoryManifest `yaml:"repositories"`
+	ConflictPolicy string               `yaml:"conflictPolicy,omitempty"`
+}
+
+// RepositoryManifest defines how a repository is mirrored, its fields match
+// the flags of the mirror command
+type RepositoryManifest struct {
+	Name                   string           `yaml:"name"`
+	URL                    string           `yaml:"url"`
+	Destination            string           `yaml:"destination"`
+	NewRootURL             string           `yaml:"newRootURL,omitempty"`
+	Credentials            Credentials      `yaml:"credentials,omitempty"`
+	DestinationCredentials Credentials      `yaml:"destinationCredentials,omitempty"`
+	AllVersions            bool             `yaml:"allVersions,omitempty"`
+	VersionConstraint      string           `yaml:"versionConstraint,omitempty"`
+	IncludePrereleases     bool             `yaml:"includePrereleases,omitempty"`
+	ExcludePrereleases     bool             `yaml:"excludePrereleases,omitempty"`
+	KeepVersions           int              `yaml:"keepVersions,omitempty"`
+	KeepVersionsPer        string           `yaml:"keepVersionsPer,omitempty"`
+	Since                  string           `yaml:"since,omitempty"`
+	Until                  string           `yaml:"until,omitempty"`
+	Include                []string         `yaml:"include,omitempty"`
+	Exclude                []string         `yaml:"exclude,omitempty"`
+	IncludeFrom            string           `yaml:"includeFrom,omitempty"`
+	Charts                 []ChartSelection `yaml:"charts,omitempty"`
+	WithDependencies       bool             `yaml:"withDependencies,omitempty"`
+	Prune                  bool             `yaml:"prune,omitempty"`
+	PruneDryRun            bool             `yaml:"pruneDryRun,omitempty"`
+	RegenerateIndex        bool             `yaml:"regenerateIndex,omitempty"`
+	Incremental            bool             `yaml:"incremental,omitempty"`
+	QuarantineDir          string           `yaml:"quarantineDir,omitempty"`
+	Provenance             bool             `yaml:"provenance,omitempty"`
+	Keyring                string           `yaml:"keyring,omitempty"`
+	RequireSigned          bool             `yaml:"requireSigned,omitempty"`
+	Concurrency            int              `yaml:"concurrency,omitempty"`
+	Retries                int              `yaml:"retries,omitempty"`
+	RetryBackoff           time.Duration    `yaml:"retryBackoff,omitempty"`
+}
+
+// Credentials references the secrets used to authenticate to a repository,
+// so that they are not written in the manifest itself
+type Credentials struct {
+	Username     string `yaml:"username,omitempty"`
+	UsernameEnv  string `yaml:"usernameEnv,omitempty"`
+	PasswordEnv  string `yaml:"passwordEnv,omitempty"`
+	PasswordFile string `yaml:"passwordFile,omitempty"`
+	CAFile       string `yaml:"caFile,omitempty"`
+	CertFile     string `yaml:"certFile,omitempty"`
+	KeyFile      string `yaml:"keyFile,omitempty"`
+}
+
+// resolve returns the username and the password referenced by the credentials.
+func (c Credentials) resolve() (string, string, error) {
+	username := c.Username
+	if c.UsernameEnv != "" {
+		value, ok := os.LookupEnv(c.UsernameEnv)
+		if !ok {
+			return "", "", errors.Errorf("environment variable %s is not set", c.UsernameEnv)
+		}
+		username = value
+	}
+
+	var password string
+	switch {
+	case c.PasswordEnv != "":
+		value, ok := os.LookupEnv(c.PasswordEnv)
+		if !ok {
+			return "", "", errors.Errorf("environment variable %s is not set", c.PasswordEnv)
+		}
+		password = value
+	case c.PasswordFile != "":
+		content, err := os.ReadFile(c.PasswordFile)
+		if err != nil {
+			return "", "", errors.Wrap(err, "cannot read password file")
+		}
+		password = strings.TrimSpace(string(content))
+	}
+	return username, password, nil
+}
+
+// LoadManifest reads and validates a manifest file.
+func LoadManifest(manifestPath string) (*Manifest, error) {
+	content, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	err = decoder.Decode(manifest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot parse manifest %s", manifestPath)
+	}
+
+	err = manifest.validate()
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid manifest %s", manifestPath)
+	}
+	return manifest, nil
+}
+
+// validate checks the repositories of the manifest the same way the mirror
+// command checks its arguments.
+func (m *Manifest) validate() error {
+	if len(m.Repositories) == 0 {
+		return errors.New("no repositories defined")
+	}
+	switch m.ConflictPolicy {
+	case "", ConflictPrefix, ConflictFirstWins, ConflictFail:
+	default:
+		return errors.Errorf("not a valid conflict policy: `%s`", m.ConflictPolicy)
+	}
+	merged := m.mergedDestinations()
+
+	names := make(map[string]bool)
+	for i, r := range m.Repositories {
+		if r.Name == "" {
+			return errors.Errorf("repository %d has no name", i+1)
+		}
+		if names[r.Name] {
+			return errors.Errorf("repository %s defined more than once", r.Name)
+		}
+		names[r.Name] = true
+
+		repoURL, err := url.Parse(r.URL)
+		if err != nil {
+			return errors.Wrapf(err, "repository %s", r.Name)
+		}
+		if !strings.Contains(repoURL.Scheme, "http") && repoURL.Scheme != registry.OCIScheme {
+			return errors.Errorf("repository %s: not a valid URL protocol: `%s`", r.Name, repoURL.Scheme)
+		}
+		if !path.IsAbs(r.Destination) && !IsRemote(r.Destination) {
+			return errors.Errorf("repository %s: please provide a full path for destination folder: `%s`", r.Name, r.Destination)
+		}
+		if len(merged[r.destination()]) > 1 {
+			if IsRemote(r.Destination) {
+				return errors.Errorf("repository %s: a destination shared by several repositories has to be a folder: `%s`", r.Name, r.Destination)
+			}
+			if strings.Contains(r.Name, "/") || r.Name == "." || r.Name == ".." {
+				return errors.Errorf("repository %s: name cannot be used as a subfolder of destination %s", r.Name, r.Destination)
+			}
+		}
+		if (r.Prune || r.PruneDryRun) && IsRemote(r.Destination) {
+			return errors.Errorf("repository %s: prune applies to a destination folder only", r.Name)
+		}
+		if r.NewRootURL != "" {
+			rootURL, err := url.Parse(r.NewRootURL)
+			if err != nil {
+				return errors.Wrapf(err, "repository %s: newRootURL not a valid URL", r.Name)
+			}
+			if !strings.Contains(rootURL.Scheme, "http") {
+				return errors.Errorf("repository %s: newRootURL not a valid URL protocol: `%s`", r.Name, rootURL.Scheme)
+			}
+		}
+		if r.IncludePrereleases && r.ExcludePrereleases {
+			return errors.Errorf("repository %s: pre-releases cannot be both included and excluded", r.Name)
+		}
+		for _, bound := range []string{r.Since, r.Until} {
+			if bound == "" {
+				continue
+			}
+			if _, err := ParseTime(bound, time.Now()); err != nil {
+				return errors.Wrapf(err, "repository %s", r.Name)
+			}
+		}
+		if r.KeepVersions < 0 {
+			return errors.Errorf("repository %s: keepVersions cannot be negative", r.Name)
+		}
+		if r.Concurrency < 0 {
+			return errors.Errorf("repository %s: concurrency has to be at least 1", r.Name)
+		}
+	}
+	return nil
+}
+
+// mergedDestinations returns the names of the repositories of every
+// destination, in the order of the manifest.
+func (m *Manifest) mergedDestinations() map[string][]string {
+	destinations := make(map[string][]string)
+	for _, r := range m.Repositories {
+		destination := r.destination()
+		destinations[destination] = append(destinations[destination], r.Name)
+	}
+	return destinations
+}
+
+// destination returns the destination of the repository, cleaned when it is
+// a folder so that the repositories sharing it are found.
+func (r RepositoryManifest) destination() string {
+	if IsRemote(r.Destination) {
+		return r.Destination
+	}
+	return path.Clean(r.Destination)
+}
+
+// SyncService structure definition
+type SyncService struct {
+	manifest     *Manifest
+	verbose      bool
+	ignoreErrors bool
+	logger       *log.Logger
+}
+
+// syncResult holds the outcome of mirroring a repository of the manifest
+type syncResult struct {
+	name   string
+	charts summary
+	push   *pushSummary
+	err    error
+}
+
+func (r syncResult) String() string {
+	if r.err != nil {
+		return fmt.Sprintf("%s: failed - %s", r.name, r.err)
+	}
+	report := fmt.Sprintf("%s: %s", r.name, r.charts)
+	if r.push != nil {
+		report += fmt.Sprintf(", %s", r.push)
+	}
+	return report
+}
+
+// NewSyncService return a new instance of SyncService
+func NewSyncService(manifest *Manifest, verbose bool, ignoreErrors bool, logger *log.Logger) SyncServiceInterface {
+	return &SyncService{
+		manifest:     manifest,
+		verbose:      verbose,
+		ignoreErrors: ignoreErrors,
+		logger:       logger,
+	}
+}
+
+// Sync mirrors every repository of the manifest, one after the other, and
+// reports the outcome of all of them. A failing repository does not stop the
+// others from being mirrored. The destinations shared by several repositories
+// are merged once all of them are mirrored.
+func (s *SyncService) Sync() error {
+	destinations := s.manifest.mergedDestinations()
+	results := make([]syncResult, 0, len(s.manifest.Repositories))
+	for _, r := range s.manifest.Repositories {
+		s.logger.Printf("syncing repository %s (%s)", r.Name, r.URL)
+		if len(destinations[r.destination()]) > 1 {
+			r.Destination = path.Join(r.Destination, r.Name)
+			// the chart URLs are kept absolute in the merged index file
+			if r.NewRootURL != "" {
+				r.NewRootURL = strings.TrimSuffix(r.NewRootURL, "/") + "/" + r.Name
+			}
+		}
+		result := s.syncRepository(r)
+		if result.err != nil {
+			s.logger.Printf("error: syncing repository %s: %s", r.Name, result.err)
+		}
+		results = append(results, result)
+	}
+
+	var mergeErrors []string
+	merged := make(map[string]bool)
+	for _, r := range s.manifest.Repositories {
+		destination := r.destination()
+		if len(destinations[destination]) < 2 || merged[destination] {
+			continue
+		}
+		merged[destination] = true
+		err := s.mergeDestination(destination, destinations[destination])
+		if err != nil {
+			s.logger.Printf("error: merging repositories into %s: %s", destination, err)
+			mergeErrors = append(mergeErrors, fmt.Sprintf("%s: merge failed - %s", destination, err))
+		}
+	}
+
+	var total summary
+	failed := 0
+	s.logger.Printf("report:")
+	for _, result := range results {
+		s.logger.Printf("  %s", result)
+		if result.err != nil {
+			failed++
+			continue
+		}
+		total.add(result.charts)
+	}
+	for _, mergeError := range mergeErrors {
+		s.logger.Printf("  %s", mergeError)
+	}
+	s.logger.Printf("repositories: %d synced, %d failed; charts: %s", len(results)-failed, failed, total)
+
+	if failed > 0 {
+		return errors.Errorf("%d of %d repositories failed", failed, len(results))
+	}
+	if len(mergeErrors) > 0 {
+		return errors.Errorf("%d destinations could not be merged", len(mergeErrors))
+	}
+	return nil
+}
+
+// syncRepository mirrors a repository of the manifest into its destination.
+func (s *SyncService) syncRepository(r RepositoryManifest) syncResult {
+	result := syncResult{name: r.Name}
+
+	username, password, err := r.Credentials.resolve()
+	if err != nil {
+		result.err = err
+		return result
+	}
+
+	var since, until time.Time
+	now := time.Now()
+	if r.Since != "" {
+		since, err = ParseTime(r.Since, now)
+		if err != nil {
+			result.err = err
+			return result
+		}
+	}
+	if r.Until != "" {
+		until, err = ParseUntil(r.Until, now)
+		if err != nil {
+			result.err = err
+			return result
+		}
+	}
+
+	include := r.Include
+	if r.IncludeFrom != "" {
+		patterns, err := ReadPatterns(r.IncludeFrom)
+		if err != nil {
+			result.err = errors.Wrap(err, "cannot read include patterns")
+			return result
+		}
+		include = append(append([]string{}, r.Include...), patterns...)
+	}
+
+	folder := r.Destination
+	if IsRemote(r.Destination) {
+		// The charts are staged in a temporary folder before being pushed
+		folder, err = os.MkdirTemp("", "helm-mirror")
+		if err != nil {
+			result.err = errors.Wrap(err, "cannot create staging folder")
+			return result
+		}
+		defer os.RemoveAll(folder)
+	} else {
+		err = os.MkdirAll(folder, 0744)
+		if err != nil {
+			result.err = errors.Wrap(err, "cannot create destination folder")
+			return result
+		}
+	}
+
+	config := repo.Entry{
+		Name:     folder,
+		URL:      r.URL,
+		Username: username,
+		Password: password,
+		CAFile:   r.Credentials.CAFile,
+		CertFile: r.Credentials.CertFile,
+		KeyFile:  r.Credentials.KeyFile,
+	}
+
+	concurrency := r.Concurrency
+	if concurrency == 0 {
+		concurrency = 1
+	}
+	options := GetOptions{
+		Incremental:        r.Incremental,
+		QuarantineDir:      r.QuarantineDir,
+		Provenance:         r.Provenance,
+		Keyring:            r.Keyring,
+		RequireSigned:      r.RequireSigned,
+		Concurrency:        concurrency,
+		Retries:            r.Retries,
+		RetryBackoff:       r.RetryBackoff,
+		VersionConstraint:  r.VersionConstraint,
+		IncludePrereleases: r.IncludePrereleases,
+		ExcludePrereleases: r.ExcludePrereleases,
+		KeepVersions:       r.KeepVersions,
+		KeepVersionsPer:    r.KeepVersionsPer,
+		Since:              since,
+		Until:              until,
+		WithDependencies:   r.WithDependencies,
+		Prune:              r.Prune,
+		PruneDryRun:        r.PruneDryRun,
+		RegenerateIndex:    r.RegenerateIndex,
+		Include:            include,
+		Exclude:            r.Exclude,
+		Charts:             r.Charts,
+	}
+
+	getService := NewGetService(config, r.AllVersions, s.verbose, s.ignoreErrors, s.logger, r.NewRootURL, "", "", options).(*GetService)
+	result.err = getService.Get()
+	result.charts = getService.summary
+	if result.err != nil || !IsRemote(r.Destination) {
+		return result
+	}
+
+	destUsername, destPassword, err := r.DestinationCredentials.resolve()
+	if err != nil {
+		result.err = err
+		return result
+	}
+	pushService := newPusher(folder, r.Destination, destUsername, destPassword, s.verbose, s.ignoreErrors, s.logger)
+	result.err = pushService.Push()
+	result.push = pushService.summary()
+	return result
+}
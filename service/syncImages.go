@@ -0,0 +1,235 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/types"
+	"github.com/pkg/errors"
+)
+
+// SyncImagesServiceInterface defines a SyncImages service
+type SyncImagesServiceInterface interface {
+	SyncImages() error
+}
+
+// SyncImagesOptions tunes the copy of the images
+type SyncImagesOptions struct {
+	// Concurrency is the number of images copied in parallel
+	Concurrency int
+	// InsecureRegistries skips the TLS verification of the registries
+	InsecureRegistries bool
+}
+
+// SyncImagesService structure definition
+type SyncImagesService struct {
+	target       string
+	destination  string
+	options      SyncImagesOptions
+	verbose      bool
+	ignoreErrors bool
+	logger       *log.Logger
+	copied       int
+	skipped      int
+	failed       int
+}
+
+// imageStatus is the outcome of the copy of an image
+type imageStatus int
+
+const (
+	imageNotCopied imageStatus = iota
+	imageCopied
+	imageSkipped
+	imageFailed
+)
+
+// imageResult holds the outcome of the copy of an image to the destination
+type imageResult struct {
+	image       string
+	destination string
+	status      imageStatus
+	err         error
+}
+
+func (r imageResult) String() string {
+	switch r.status {
+	case imageCopied:
+		return fmt.Sprintf("%s -> %s: copied", r.image, r.destination)
+	case imageSkipped:
+		return fmt.Sprintf("%s -> %s: already present", r.image, r.destination)
+	case imageFailed:
+		return fmt.Sprintf("%s -> %s: failed - %s", r.image, r.destination, r.err)
+	}
+	return fmt.Sprintf("%s: not copied", r.image)
+}
+
+// NewSyncImagesService return a new instance of SyncImagesService
+func NewSyncImagesService(target string, destination string, options SyncImagesOptions, verbose bool, ignoreErrors bool, logger *log.Logger) SyncImagesServiceInterface {
+	return &SyncImagesService{
+		target:       target,
+		destination:  destination,
+		options:      options,
+		verbose:      verbose,
+		ignoreErrors: ignoreErrors,
+		logger:       logger,
+	}
+}
+
+// SyncImages copies the images found in the target, a chart or a folder of
+// charts, into the destination repository. An image is copied under the
+// destination with its repository path, docker.io/library/nginx:1.25 into
+// registry.internal/mirror/library/nginx:1.25. The images whose manifest is
+// already in the destination are skipped, and the manifest lists are copied
+// with all their images. The outcome of every image is reported. When errors
+// are not ignored no new copy is started after a failure.
+func (s *SyncImagesService) SyncImages() error {
+	if !ImageCopySupported {
+		s.logger.Printf("error: %s", errImageCopyUnsupported)
+		return errImageCopyUnsupported
+	}
+	destination, err := reference.ParseNormalizedNamed(s.destination)
+	if err != nil || !reference.IsNameOnly(destination) {
+		s.logger.Printf("error: not a valid destination repository: `%s`", s.destination)
+		return errors.Errorf("not a valid destination repository: `%s`", s.destination)
+	}
+	images := &ImagesService{target: s.target, verbose: s.verbose, ignoreErrors: s.ignoreErrors, logger: s.logger}
+	err = images.process()
+	if err != nil {
+		return err
+	}
+	list := images.imageList()
+
+	results := make([]imageResult, len(list))
+	for i, image := range list {
+		results[i].image = image
+	}
+	s.copyImages(results)
+
+	s.logger.Printf("report:")
+	for _, r := range results {
+		s.logger.Printf("  %s", r)
+		switch r.status {
+		case imageCopied:
+			s.copied++
+		case imageSkipped:
+			s.skipped++
+		case imageFailed:
+			s.failed++
+		}
+	}
+	s.logger.Printf("images: %d copied, %d already present, %d failed", s.copied, s.skipped, s.failed)
+
+	if s.failed > 0 && !s.ignoreErrors {
+		return errors.Errorf("%d of %d images failed", s.failed, len(results))
+	}
+	return nil
+}
+
+// copyImages copies the images of the results through a pool of workers
+// bounded by the concurrency option, and records the outcome of every copy.
+func (s *SyncImagesService) copyImages(results []imageResult) {
+	workers := s.options.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	sys := &types.SystemContext{
+		DockerInsecureSkipTLSVerify: types.NewOptionalBool(s.options.InsecureRegistries),
+	}
+
+	jobs := make(chan int)
+	var failed int32
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				r := &results[i]
+				var copied bool
+				copied, r.err = s.copyImage(context.Background(), sys, r)
+				switch {
+				case r.err != nil:
+					r.status = imageFailed
+					if s.ignoreErrors {
+						s.logger.Printf("WARNING: copying image %s - %s", r.image, r.err)
+					} else {
+						s.logger.Printf("error: copying image %s: %s", r.image, r.err)
+						atomic.StoreInt32(&failed, 1)
+					}
+				case copied:
+					r.status = imageCopied
+				default:
+					r.status = imageSkipped
+				}
+			}
+		}()
+	}
+
+	for i := range results {
+		if atomic.LoadInt32(&failed) == 1 {
+			break
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// copyImage copies an image to its destination unless already there. It
+// returns whether the image was copied.
+func (s *SyncImagesService) copyImage(ctx context.Context, sys *types.SystemContext, r *imageResult) (bool, error) {
+	named, err := parseImageReference(r.image)
+	if err != nil {
+		return false, err
+	}
+	target, err := syncImageDestination(named, s.destination)
+	if err != nil {
+		return false, err
+	}
+	r.destination = target.String()
+
+	srcRef, err := docker.NewReference(named)
+	if err != nil {
+		return false, err
+	}
+	destRef, err := docker.NewReference(target)
+	if err != nil {
+		return false, err
+	}
+	copied, err := copyImage(ctx, sys, srcRef, destRef, true)
+	if err != nil {
+		return false, err
+	}
+	if s.verbose {
+		if copied {
+			s.logger.Printf("copied image %s to %s", named, target)
+		} else {
+			s.logger.Printf("skipping image %s: already in %s", named, target)
+		}
+	}
+	return copied, nil
+}
+
+// syncImageDestination returns the reference of an image under the
+// destination repository, with the repository path of the image and its tag
+// or digest.
+func syncImageDestination(image reference.Named, destination string) (reference.Named, error) {
+	target, err := reference.ParseNormalizedNamed(strings.TrimSuffix(destination, "/") + "/" + reference.Path(image))
+	if err != nil {
+		return nil, err
+	}
+	if digested, ok := image.(reference.Digested); ok {
+		return reference.WithDigest(target, digested.Digest())
+	}
+	if tagged, ok := image.(reference.Tagged); ok {
+		return reference.WithTag(target, tagged.Tag())
+	}
+	return target, nil
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+
+	"github.com/kplachkov/helm-mirror/fixtures"
+)
+
+func TestNewSyncImagesService(t *testing.T) {
+	options := SyncImagesOptions{Concurrency: 2, InsecureRegistries: true}
+	want := &SyncImagesService{target: "/folder", destination: "registry.internal/mirror", options: options, logger: fakeLogger}
+	if got := NewSyncImagesService("/folder", "registry.internal/mirror", options, false, false, fakeLogger); !reflect.DeepEqual(got, want) {
+		t.Errorf("NewSyncImagesService() = %v, want %v", got, want)
+	}
+}
+
+func TestSyncImagesService_SyncImages(t *testing.T) {
+	if !ImageCopySupported {
+		t.Skip(errImageCopyUnsupported)
+	}
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	source, err := fixtures.StartRegistry()
+	if err != nil {
+		t.Fatalf("starting registry: %s", err)
+	}
+	app, err := fixtures.PushImage(source, "app", "1.0", []byte("app layer"))
+	if err != nil {
+		t.Fatalf("pushing image: %s", err)
+	}
+	amd64, err := fixtures.PushImage(source, "multi", "amd64", []byte("shared layer"), []byte("amd64 layer"))
+	if err != nil {
+		t.Fatalf("pushing image: %s", err)
+	}
+	arm64, err := fixtures.PushImage(source, "multi", "arm64", []byte("shared layer"), []byte("arm64 layer"))
+	if err != nil {
+		t.Fatalf("pushing image: %s", err)
+	}
+	amd64.Platform = &imgspecv1.Platform{Architecture: "amd64", OS: "linux"}
+	arm64.Platform = &imgspecv1.Platform{Architecture: "arm64", OS: "linux"}
+	multi, err := fixtures.PushIndex(source, "multi", "2.0", amd64, arm64)
+	if err != nil {
+		t.Fatalf("pushing index: %s", err)
+	}
+	legacy, err := fixtures.PushDockerImage(source, "legacy", "1.0", []byte("legacy layer"))
+	if err != nil {
+		t.Fatalf("pushing image: %s", err)
+	}
+
+	packageImageChart := func(folder string, name string, image string) {
+		os.MkdirAll(folder, 0744)
+		_, err := chartutil.Save(&chart.Chart{
+			Metadata:  &chart.Metadata{APIVersion: chart.APIVersionV2, Name: name, Version: "1.0.0"},
+			Templates: []*chart.File{{Name: "templates/pod.yaml", Data: []byte("image: " + image + "\n")}},
+		}, folder)
+		if err != nil {
+			t.Fatalf("packaging chart: %s", err)
+		}
+	}
+	folder := path.Join(dir, "folder")
+	packageImageChart(folder, "a", source+"/app:1.0")
+	packageImageChart(folder, "b", source+"/multi:2.0")
+	packageImageChart(folder, "c", source+"/legacy:1.0")
+	broken := path.Join(dir, "broken")
+	packageImageChart(broken, "a", source+"/app:1.0")
+	packageImageChart(broken, "c", source+"/missing:1.0")
+
+	tests := []struct {
+		name         string
+		target       string
+		destination  string
+		concurrency  int
+		ignoreErrors bool
+		wantSkipped  int
+		wantErr      bool
+	}{
+		{"1", folder, "mirror", 2, false, 3, false},
+		{"2", folder, "mirror", 1, false, 3, false},
+		{"3", broken, "mirror", 1, false, 0, true},
+		{"4", broken, "mirror", 1, true, 1, false},
+		{"5", folder, "mirror:1.0", 1, false, 0, true},
+		{"6", path.Join(dir, "none"), "mirror", 1, false, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			destination, err := fixtures.StartRegistry()
+			if err != nil {
+				t.Fatalf("starting registry: %s", err)
+			}
+			options := SyncImagesOptions{Concurrency: tt.concurrency, InsecureRegistries: true}
+			s := NewSyncImagesService(tt.target, destination+"/"+tt.destination, options, false, tt.ignoreErrors, fakeLogger)
+			if err := s.SyncImages(); (err != nil) != tt.wantErr {
+				t.Fatalf("SyncImagesService.SyncImages() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			// the digests are kept, Docker media types included, and the
+			// images of the index are copied
+			type copied struct{ repository, ref string }
+			want := map[copied]digest.Digest{{"mirror/app", "1.0"}: app.Digest}
+			if tt.target == folder {
+				want[copied{"mirror/multi", "2.0"}] = multi.Digest
+				want[copied{"mirror/multi", amd64.Digest.String()}] = amd64.Digest
+				want[copied{"mirror/multi", arm64.Digest.String()}] = arm64.Digest
+				want[copied{"mirror/legacy", "1.0"}] = legacy.Digest
+			}
+			for c, d := range want {
+				got, err := fixtures.ManifestDigest(destination, c.repository, c.ref)
+				if err != nil {
+					t.Errorf("SyncImagesService.SyncImages() did not copy %s:%s: %s", c.repository, c.ref, err)
+					continue
+				}
+				if got != d {
+					t.Errorf("SyncImagesService.SyncImages() digest of %s:%s = %s, want %s", c.repository, c.ref, got, d)
+				}
+			}
+
+			// a second run finds the images in the destination
+			again := &SyncImagesService{target: tt.target, destination: destination + "/" + tt.destination, options: options, ignoreErrors: tt.ignoreErrors, logger: fakeLogger}
+			again.SyncImages()
+			if again.copied != 0 || again.skipped != tt.wantSkipped {
+				t.Errorf("SyncImagesService.SyncImages() again copied %d, skipped %d, want 0, %d", again.copied, again.skipped, tt.wantSkipped)
+			}
+		})
+	}
+}
+
+func Test_syncImageDestination(t *testing.T) {
+	tests := []struct {
+		name        string
+		image       string
+		destination string
+		want        string
+	}{
+		{"1", "nginx:1.25", "registry.internal/mirror", "registry.internal/mirror/library/nginx:1.25"},
+		{"2", "quay.io/org/app:1.0", "registry.internal/mirror/", "registry.internal/mirror/org/app:1.0"},
+		{"3", "quay.io/org/app@sha256:b5b2b2c507a0944348e0303114d8d93aaaa081732b86451d9bce1f432a537bc7", "registry.internal", "registry.internal/org/app@sha256:b5b2b2c507a0944348e0303114d8d93aaaa081732b86451d9bce1f432a537bc7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			named, err := parseImageReference(tt.image)
+			if err != nil {
+				t.Fatalf("parsing image: %s", err)
+			}
+			got, err := syncImageDestination(named, tt.destination)
+			if err != nil {
+				t.Fatalf("syncImageDestination() error = %v", err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("syncImageDestination() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/repo"
+
+	"github.com/kplachkov/helm-mirror/fixtures"
+)
+
+func TestLoadManifest(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		name     string
+		manifest string
+		wantErr  bool
+	}{
+		{"1", "repositories:\n- name: a\n  url: https://url\n  destination: /target\n  charts:\n  - name: chart\n    version: 1.0.0\n  retryBackoff: 2s\n", false},
+		{"2", "repositories:\n- name: a\n  url: oci://registry/charts/chart\n  destination: oci://registry/mirror\n", false},
+		{"3", "repositories: []\n", true},
+		{"4", "repositories:\n- name: a\n  url: https://url\n  destination: /target\n  unknown: true\n", true},
+		{"5", "repositories:\n- url: https://url\n  destination: /target\n", true},
+		{"6", "repositories:\n- name: a\n  url: https://url\n  destination: /target\n- name: a\n  url: https://url2\n  destination: /target2\n", true},
+		{"7", "repositories:\n- name: a\n  url: ftp://url\n  destination: /target\n", true},
+		{"8", "repositories:\n- name: a\n  url: https://url\n  destination: target\n", true},
+		{"9", "repositories:\n- name: a\n  url: https://url\n  destination: /target\n  newRootURL: ftp://test\n", true},
+		{"10", "repositories:\n- name: a\n  url: https://url\n  destination: /target\n  concurrency: -1\n", true},
+		{"11", "repositories: [", true},
+		{"12", "repositories:\n- name: a\n  url: https://url\n  destination: /target\n  versionConstraint: 1.x\n  excludePrereleases: true\n", false},
+		{"13", "repositories:\n- name: a\n  url: https://url\n  destination: /target\n  includePrereleases: true\n  excludePrereleases: true\n", true},
+		{"14", "repositories:\n- name: a\n  url: https://url\n  destination: /target\n  keepVersions: -1\n", true},
+		{"15", "repositories:\n- name: a\n  url: https://url\n  destination: /target\n  since: 90d\n  until: 2023-12-31\n", false},
+		{"16", "repositories:\n- name: a\n  url: https://url\n  destination: /target\n  since: yesterday\n", true},
+		{"17", "repositories:\n- name: a\n  url: https://url\n  destination: /target\n  withDependencies: true\n", false},
+		{"18", "repositories:\n- name: a\n  url: https://url\n  destination: /target\n  prune: true\n", false},
+		{"19", "repositories:\n- name: a\n  url: https://url\n  destination: oci://registry/mirror\n  pruneDryRun: true\n", true},
+		{"20", "repositories:\n- name: a\n  url: https://url\n  destination: /target\n  regenerateIndex: true\n", false},
+		{"21", "conflictPolicy: prefix\nrepositories:\n- name: a\n  url: https://url\n  destination: /target\n- name: b\n  url: https://url2\n  destination: /target/\n", false},
+		{"22", "conflictPolicy: newest\nrepositories:\n- name: a\n  url: https://url\n  destination: /target\n", true},
+		{"23", "repositories:\n- name: a\n  url: https://url\n  destination: oci://registry/mirror\n- name: b\n  url: https://url2\n  destination: oci://registry/mirror\n", true},
+		{"24", "repositories:\n- name: ..\n  url: https://url\n  destination: /target\n- name: b\n  url: https://url2\n  destination: /target\n", true},
+		{"25", "repositories:\n- name: a\n  url: https://url\n  destination: cm://chartmuseum\n  prune: true\n", true},
+		{"26", "repositories:\n- name: a\n  url: https://url\n  destination: cm+http://chartmuseum:8080\n", false},
+		{"27", "repositories:\n- name: a\n  url: https://url\n  destination: s3://bucket/charts\n", false},
+		{"28", "repositories:\n- name: a\n  url: https://url\n  destination: s3://bucket/charts\n  pruneDryRun: true\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifestPath := path.Join(dir, "mirror.yaml")
+			err := os.WriteFile(manifestPath, []byte(tt.manifest), 0644)
+			if err != nil {
+				t.Fatalf("writing manifest: %s", err)
+			}
+			if _, err := LoadManifest(manifestPath); (err != nil) != tt.wantErr {
+				t.Errorf("LoadManifest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := LoadManifest(path.Join(dir, "missing.yaml")); err == nil {
+		t.Errorf("LoadManifest() expected an error for a missing file")
+	}
+}
+
+func TestCredentials_resolve(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	passwordFile := path.Join(dir, "password")
+	err = os.WriteFile(passwordFile, []byte("filepassword\n"), 0600)
+	if err != nil {
+		t.Fatalf("writing password file: %s", err)
+	}
+	t.Setenv("HELM_MIRROR_TEST_USERNAME", "envuser")
+	t.Setenv("HELM_MIRROR_TEST_PASSWORD", "envpassword")
+	tests := []struct {
+		name         string
+		credentials  Credentials
+		wantUsername string
+		wantPassword string
+		wantErr      bool
+	}{
+		{"1", Credentials{}, "", "", false},
+		{"2", Credentials{Username: "user", PasswordEnv: "HELM_MIRROR_TEST_PASSWORD"}, "user", "envpassword", false},
+		{"3", Credentials{UsernameEnv: "HELM_MIRROR_TEST_USERNAME", PasswordFile: passwordFile}, "envuser", "filepassword", false},
+		{"4", Credentials{UsernameEnv: "HELM_MIRROR_TEST_MISSING"}, "", "", true},
+		{"5", Credentials{PasswordEnv: "HELM_MIRROR_TEST_MISSING"}, "", "", true},
+		{"6", Credentials{PasswordFile: path.Join(dir, "missing")}, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			username, password, err := tt.credentials.resolve()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Credentials.resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if username != tt.wantUsername || password != tt.wantPassword {
+				t.Errorf("Credentials.resolve() = %s, %s, want %s, %s", username, password, tt.wantUsername, tt.wantPassword)
+			}
+		})
+	}
+}
+
+func TestSyncService_Sync(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	svr := fixtures.StartHTTPServer()
+	defer svr.Shutdown(context.Background())
+	fixtures.WaitForServer("http://127.0.0.1:1793/alive")
+
+	manifest := &Manifest{Repositories: []RepositoryManifest{
+		{Name: "all", URL: "http://127.0.0.1:1793", Destination: path.Join(dir, "all"), AllVersions: true},
+		{Name: "selected", URL: "http://127.0.0.1:1793", Destination: path.Join(dir, "selected"), Charts: []ChartSelection{
+			{Name: "chart1"},
+			{Name: "chart2", Version: "0.0.0-rc1"},
+		}},
+		{Name: "unreachable", URL: "http://127.0.0.1:1111", Destination: path.Join(dir, "unreachable")},
+	}}
+
+	var buf bytes.Buffer
+	s := NewSyncService(manifest, false, true, log.New(&buf, "", 0))
+	if err := s.Sync(); err == nil {
+		t.Errorf("SyncService.Sync() expected an error for the unreachable repository")
+	}
+
+	tests := []struct {
+		name  string
+		file  string
+		exist bool
+	}{
+		{"1", path.Join(dir, "all", "chart1-2.11.0.tgz"), true},
+		{"2", path.Join(dir, "all", "chart2-1.0.1.tgz"), true},
+		{"3", path.Join(dir, "all", "chart2-0.0.0-rc1.tgz"), true},
+		{"4", path.Join(dir, "all", indexFileName), true},
+		{"5", path.Join(dir, "selected", "chart1-2.11.0.tgz"), true},
+		{"6", path.Join(dir, "selected", "chart2-1.0.1.tgz"), false},
+		{"7", path.Join(dir, "selected", "chart2-0.0.0-rc1.tgz"), true},
+		{"8", path.Join(dir, "selected", "chart3-0.0.1-rc1.tgz"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fileExists(tt.file); got != tt.exist {
+				t.Errorf("file %s exists = %v, want %v", tt.file, got, tt.exist)
+			}
+		})
+	}
+
+	report := buf.String()
+	for _, want := range []string{
+		"  all: 4 downloaded, 0 replaced, 0 skipped, 1 failed\n",
+		"  selected: 2 downloaded, 0 replaced, 0 skipped, 0 failed\n",
+		"  unreachable: failed - ",
+		"repositories: 2 synced, 1 failed; charts: 6 downloaded, 0 replaced, 0 skipped, 1 failed\n",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("SyncService.Sync() report = %s, want %s", report, want)
+		}
+	}
+}
+
+func TestSyncService_Sync_s3(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	upstream := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer upstream.Close()
+	packageTestChart(t, dir, "a", "1.0.0")
+	packageTestChart(t, dir, "b", "1.0.0")
+	writeTestIndex(t, dir, upstream.URL)
+	s3 := fixtures.StartS3("mirror")
+	defer s3.Close()
+	t.Setenv("AWS_ENDPOINT_URL", s3.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "access")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	manifest := &Manifest{Repositories: []RepositoryManifest{
+		{Name: "s3", URL: upstream.URL, Destination: "s3://mirror/charts", NewRootURL: "https://mirror.s3.amazonaws.com/charts"},
+	}}
+	var buf bytes.Buffer
+	s := NewSyncService(manifest, false, false, log.New(&buf, "", 0))
+	if err := s.Sync(); err != nil {
+		t.Errorf("SyncService.Sync() error = %v", err)
+	}
+
+	want := []string{"charts/a-1.0.0.tgz", "charts/b-1.0.0.tgz", "charts/" + indexFileName}
+	if got := s3.Keys("mirror"); !reflect.DeepEqual(got, want) {
+		t.Errorf("SyncService.Sync() objects = %v, want %v", got, want)
+	}
+	indexPath := path.Join(dir, "pushed-"+indexFileName)
+	os.WriteFile(indexPath, s3.Object("mirror", "charts/"+indexFileName), 0644)
+	index, err := repo.LoadIndexFile(indexPath)
+	if err != nil {
+		t.Fatalf("loading index: %s", err)
+	}
+	if url := index.Entries["a"][0].URLs[0]; url != "https://mirror.s3.amazonaws.com/charts/a-1.0.0.tgz" {
+		t.Errorf("SyncService.Sync() chart URL = %s", url)
+	}
+	if !strings.Contains(buf.String(), "  s3: 2 downloaded, 0 replaced, 0 skipped, 0 failed, 2 pushed, 0 already pushed, 0 not pushed\n") {
+		t.Errorf("SyncService.Sync() report = %s", buf.String())
+	}
+}